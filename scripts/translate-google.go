@@ -2,68 +2,308 @@ package main
 
 import (
 	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
+	"encoding/xml"
+	"errors"
 	"flag"
 	"fmt"
+	"html"
+	"io"
 	"io/ioutil"
+	"math/rand"
+	"net"
 	"net/http"
+	"net/url"
 	"os"
 	"path/filepath"
 	"regexp"
+	"sort"
+	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"text/tabwriter"
 	"time"
+	"unicode"
+	"unicode/utf8"
 )
 
-// 缓存条目结构（包含时间戳）
+// 缓存条目结构（包含时间戳，仅作记录；缓存按原文内容命中，不再按时间过期）
 type CacheEntry struct {
-	Translation string    `json:"translation"`
-	Timestamp   int64     `json:"timestamp"`
+	Translation string `json:"translation"`
+	Timestamp   int64  `json:"timestamp"`
 }
 
 // 缓存元数据结构
 type FileCacheMetadata struct {
+	// 键为 cacheKey.String()：provider|lang|protection|text
 	Entries map[string]CacheEntry `json:"entries"`
 }
 
+// 翻译锁文件：记录每个键翻译时英文原文的哈希，用于判断译文是否过期
+// 路径：<lockRootDir>/<locale>/<namespace>.json（与 messages/<locale>/<namespace>.json 一一对应）
+type TranslationLock struct {
+	// 键路径 -> 英文原文哈希
+	Hashes map[string]string `json:"hashes"`
+}
+
 // 专有名词配置结构
 type ProperNounsConfig struct {
-	Description string   `json:"description"`
-	ProperNouns []string `json:"properNouns"`
+	Description string       `json:"description"`
+	ProperNouns []ProperNoun `json:"properNouns"`
+}
+
+// 专有名词的匹配方式
+const (
+	matchWord            = "word"             // 整词匹配（默认，区分大小写）
+	matchCaseInsensitive = "case-insensitive" // 整词匹配，忽略大小写
+	matchRegex           = "regex"            // 正则表达式，不检查词边界
+)
+
+// 专有名词条目：配置中可以直接写字符串（整词匹配），也可以写对象指定匹配方式
+// 例如 {"term": "Flux 2 Pro", "match": "case-insensitive"}、{"term": "RTX \\d{4}", "match": "regex"}
+type ProperNoun struct {
+	Term  string `json:"term"`
+	Match string `json:"match,omitempty"`
+
+	pattern *regexp.Regexp
+}
+
+// 支持字符串和对象两种写法
+func (n *ProperNoun) UnmarshalJSON(data []byte) error {
+	var term string
+	if err := json.Unmarshal(data, &term); err == nil {
+		*n = ProperNoun{Term: term}
+		return nil
+	}
+
+	type rawProperNoun ProperNoun
+	var raw rawProperNoun
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return fmt.Errorf("专有名词条目必须是字符串或对象: %s", data)
+	}
+	*n = ProperNoun(raw)
+	return nil
+}
+
+// 检查匹配方式，正则条目在此编译（字面条目由 Aho-Corasick 自动机匹配）
+func (n *ProperNoun) compile() error {
+	var err error
+	switch n.Match {
+	case "", matchWord, matchCaseInsensitive:
+	case matchRegex:
+		n.pattern, err = regexp.Compile(n.Term)
+		if err != nil {
+			return fmt.Errorf("专有名词正则 %q 无效: %v", n.Term, err)
+		}
+	default:
+		return fmt.Errorf("专有名词 %q 的匹配方式无效: %s (可选: word, case-insensitive, regex)", n.Term, n.Match)
+	}
+	return nil
+}
+
+// 术语表配置：英文术语 -> 各语言（messages/<locale> 目录名）规定使用的译法
+type GlossaryConfig struct {
+	Description string                       `json:"description"`
+	Terms       map[string]map[string]string `json:"terms"`
+	// 语言 -> 预先创建的 Google v3 术语表资源名（projects/<p>/locations/<l>/glossaries/<id>），需配合 -google-token 使用
+	GoogleGlossaries map[string]string `json:"googleGlossaries"`
+}
+
+// 某个目标语言下的一条术语
+type glossaryTerm struct {
+	Source string
+	Target string
+}
+
+// 翻译缓存键：同一原文在不同翻译服务、目标语言和保护配置下的译文互不相同
+type cacheKey struct {
+	Provider   string // 翻译服务名称（LLM 含模型名和接口地址）
+	Lang       string // 目标语言
+	Protection string // 保护配置指纹（专有名词列表等）
+	Text       string // 英文原文
+}
+
+// 磁盘缓存中使用的字符串形式：provider|lang|protection|text
+func (k cacheKey) String() string {
+	return k.Provider + "|" + k.Lang + "|" + k.Protection + "|" + k.Text
 }
 
-// 翻译缓存（内存）
-var translationCache = make(map[string]string)
+// 翻译缓存（内存），多个 worker 并发读写，需要加锁
+var translationCache = make(map[cacheKey]string)
+var translationCacheMu sync.RWMutex
+
+// 保护配置指纹：专有名词等配置变化后，旧的缓存译文不再命中
+var protectionFingerprint = ""
+
+// 专有名词列表（从配置文件加载，匹配模式已编译）
+var properNouns []ProperNoun
+
+// 专有名词匹配器（随 properNouns 一起构建）
+var properNounIndex = newProperNounMatcher(nil)
 
-// 专有名词列表（从配置文件加载）
-var properNouns []string
+// 术语表（从配置文件加载）
+var glossary GlossaryConfig
+
+// 术语表英文术语的自动机（忽略大小写，查找时检查词边界），第 i 个词条为 glossarySources[i]
+var glossaryMatcher = newACMatcher(nil)
+var glossarySources []string
 
 // 缓存根目录
 var cacheRootDir = ""
 
-var requestCount = 0
-var lastRequestTime = time.Now()
-var cacheHits = 0
-var cacheMisses = 0
+// 翻译锁文件根目录
+var lockRootDir = ""
+
+// 增量模式：保留目标文件中已有的翻译，只翻译缺失或原文已变化的键
+var incrementalMode = false
+
+// 疑似未翻译的译文（与英文相同或不是目标语言）是否跳过缓存重新翻译一次
+var retryUntranslated = false
+
+// 超出长度限制的译文是否请翻译服务缩短（仅 LLM 服务支持）
+var shortenOverLength = false
+
+// 并发 worker 数量（文件和语言共享同一个 worker 池）
+var workerCount = 1
+
+// 全局速率限制器（所有 worker 共享）
+var rateLimiter = NewRateLimiter(2, 1)
+
+// 翻译统计（并发安全）
+type TranslationStats struct {
+	Requests    atomic.Int64
+	CacheHits   atomic.Int64
+	CacheMisses atomic.Int64
+}
+
+// 全局统计
+var totalStats = &TranslationStats{}
+
+// 记录统计：同时累加到指定统计（如单个语言）和全局统计
+func recordStats(stats *TranslationStats, requests, hits, misses int) {
+	for _, s := range []*TranslationStats{stats, totalStats} {
+		if s == nil {
+			continue
+		}
+		s.Requests.Add(int64(requests))
+		s.CacheHits.Add(int64(hits))
+		s.CacheMisses.Add(int64(misses))
+	}
+}
+
+// 读取内存缓存
+func getCachedTranslation(key cacheKey) (string, bool) {
+	translationCacheMu.RLock()
+	defer translationCacheMu.RUnlock()
+	translated, ok := translationCache[key]
+	return translated, ok
+}
+
+// 写入内存缓存
+func putCachedTranslation(key cacheKey, translated string) {
+	translationCacheMu.Lock()
+	defer translationCacheMu.Unlock()
+	translationCache[key] = translated
+}
+
+// 删除内存和磁盘缓存中的译文（重新翻译前使用）
+func forgetCachedTranslation(key cacheKey, fileCache map[string]CacheEntry) {
+	translationCacheMu.Lock()
+	delete(translationCache, key)
+	translationCacheMu.Unlock()
+	delete(fileCache, key.String())
+}
+
+// 保护方案版本：保护/还原逻辑变化时递增，使旧缓存失效
+const protectionSchemeVersion = "4"
+
+// 计算保护配置指纹（保护方案版本 + 专有名词列表 + 术语表的哈希）
+func computeProtectionFingerprint() string {
+	nouns, _ := json.Marshal(properNouns)
+	terms, _ := json.Marshal(glossary)
+	return sourceHash(protectionSchemeVersion + string(nouns) + string(terms))[:8]
+}
+
+// 令牌桶速率限制器：按固定速率补充令牌，允许一定的突发请求，可被多个 goroutine 共享
+type RateLimiter struct {
+	mu     sync.Mutex
+	rate   float64 // 每秒补充的令牌数，<= 0 表示不限速
+	burst  float64 // 令牌桶容量
+	tokens float64
+	last   time.Time
+
+	// 时钟和等待函数（测试时替换为假时钟）
+	now   func() time.Time
+	sleep func(time.Duration)
+}
+
+// 创建速率限制器
+func NewRateLimiter(rate float64, burst int) *RateLimiter {
+	if burst < 1 {
+		burst = 1
+	}
+	return &RateLimiter{
+		rate:   rate,
+		burst:  float64(burst),
+		tokens: float64(burst),
+		last:   time.Now(),
+		now:    time.Now,
+		sleep:  time.Sleep,
+	}
+}
+
+// 获取一个令牌，令牌不足时阻塞等待
+// 先预留令牌（余额可以为负）再在锁外等待，保证并发调用按顺序排队
+func (r *RateLimiter) Wait() {
+	if r.rate <= 0 {
+		return
+	}
+
+	r.mu.Lock()
+	now := r.now()
+	r.tokens += now.Sub(r.last).Seconds() * r.rate
+	if r.tokens > r.burst {
+		r.tokens = r.burst
+	}
+	r.last = now
+	r.tokens--
+	var wait time.Duration
+	if r.tokens < 0 {
+		wait = time.Duration(-r.tokens / r.rate * float64(time.Second))
+	}
+	r.mu.Unlock()
+
+	r.sleep(wait)
+}
 
 // 从磁盘加载文件的缓存
-func loadFileCache(targetDir, fileName string) map[string]CacheEntry {
+func loadFileCache(targetDir, fileName string) *FileCacheMetadata {
+	empty := &FileCacheMetadata{Entries: make(map[string]CacheEntry)}
+
 	// 缓存文件路径：.deepl_cache/it/admin.json (对应 messages/it/admin.json)
 	cachePath := filepath.Join(cacheRootDir, filepath.Base(targetDir), fileName)
 	data, err := ioutil.ReadFile(cachePath)
 	if err != nil {
-		return make(map[string]CacheEntry)
+		return empty
 	}
 
 	var metadata FileCacheMetadata
 	if err := json.Unmarshal(data, &metadata); err != nil {
-		return make(map[string]CacheEntry)
+		return empty
+	}
+	if metadata.Entries == nil {
+		metadata.Entries = make(map[string]CacheEntry)
 	}
 
-	return metadata.Entries
+	return &metadata
 }
 
 // 保存文件的缓存到磁盘
-func saveFileCache(targetDir, fileName string, cache map[string]CacheEntry) error {
+func saveFileCache(targetDir, fileName string, metadata *FileCacheMetadata) error {
 	// 缓存文件路径：.deepl_cache/it/admin.json
 	cacheSubDir := filepath.Join(cacheRootDir, filepath.Base(targetDir))
 	if err := os.MkdirAll(cacheSubDir, 0755); err != nil {
@@ -71,7 +311,6 @@ func saveFileCache(targetDir, fileName string, cache map[string]CacheEntry) erro
 	}
 
 	cachePath := filepath.Join(cacheSubDir, fileName)
-	metadata := FileCacheMetadata{Entries: cache}
 
 	data, err := json.MarshalIndent(metadata, "", "  ")
 	if err != nil {
@@ -80,9 +319,56 @@ func saveFileCache(targetDir, fileName string, cache map[string]CacheEntry) erro
 	return ioutil.WriteFile(cachePath, data, 0644)
 }
 
-// 检查缓存条目是否过期（24小时）
-func isCacheExpired(timestamp int64) bool {
-	return time.Since(time.Unix(timestamp, 0)) > 24*time.Hour
+// 计算英文原文的哈希（sha256 前 16 位十六进制）
+func sourceHash(text string) string {
+	sum := sha256.Sum256([]byte(text))
+	return hex.EncodeToString(sum[:])[:16]
+}
+
+// 翻译锁文件路径：.i18n-lock/de/home.json (对应 messages/de/home.json)
+func lockFilePath(targetDir, fileName string) string {
+	return filepath.Join(lockRootDir, filepath.Base(targetDir), fileName)
+}
+
+// 加载翻译锁文件，不存在时返回空锁
+func loadTranslationLock(targetDir, fileName string) (*TranslationLock, error) {
+	lock := &TranslationLock{Hashes: make(map[string]string)}
+
+	data, err := ioutil.ReadFile(lockFilePath(targetDir, fileName))
+	if os.IsNotExist(err) {
+		return lock, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("读取锁文件失败: %v", err)
+	}
+	if err := json.Unmarshal(data, lock); err != nil {
+		return nil, fmt.Errorf("解析锁文件失败: %v", err)
+	}
+	if lock.Hashes == nil {
+		lock.Hashes = make(map[string]string)
+	}
+	return lock, nil
+}
+
+// 保存翻译锁文件
+func saveTranslationLock(targetDir, fileName string, lock *TranslationLock) error {
+	lockPath := lockFilePath(targetDir, fileName)
+	if err := os.MkdirAll(filepath.Dir(lockPath), 0755); err != nil {
+		return fmt.Errorf("无法创建锁文件目录: %v", err)
+	}
+
+	data, err := json.MarshalIndent(lock, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(lockPath, append(data, '\n'), 0644)
+}
+
+// 判断键的译文是否过期：锁文件中记录的原文哈希与当前原文不一致
+// 没有记录的键（如手工添加的译文）不视为过期
+func isStale(lock *TranslationLock, path, sourceText string) bool {
+	recorded, ok := lock.Hashes[path]
+	return ok && recorded != sourceHash(sourceText)
 }
 
 // 加载专有名词配置
@@ -91,7 +377,7 @@ func loadProperNounsConfig(configPath string) error {
 	if err != nil {
 		// 如果文件不存在，使用默认的空列表
 		fmt.Printf("⚠️  未找到专有名词配置文件: %s，将使用空列表\n", configPath)
-		properNouns = []string{}
+		properNouns = []ProperNoun{}
 		return nil
 	}
 
@@ -100,619 +386,5906 @@ func loadProperNounsConfig(configPath string) error {
 		return fmt.Errorf("解析专有名词配置失败: %v", err)
 	}
 
-	properNouns = config.ProperNouns
+	if err := setProperNouns(config.ProperNouns); err != nil {
+		return err
+	}
 	fmt.Printf("✅ 成功加载 %d 个专有名词\n", len(properNouns))
 	return nil
 }
 
-// 调用 Google Cloud Translation API 翻译单批文本（最多 128 个）
-func translateSingleBatch(apiKey string, batchTexts []string, targetLang string) ([]string, error) {
-	// Google Cloud Translation API 端点
-	url := "https://translation.googleapis.com/language/translate/v2"
-	requestURL := fmt.Sprintf("%s?key=%s", url, apiKey)
+// 设置专有名词列表：编译正则条目并构建匹配器
+func setProperNouns(entries []ProperNoun) error {
+	nouns := make([]ProperNoun, 0, len(entries))
+	for _, noun := range entries {
+		if noun.Term == "" {
+			continue
+		}
+		if err := noun.compile(); err != nil {
+			return err
+		}
+		nouns = append(nouns, noun)
+	}
 
-	// 构建请求体
-	type GoogleTranslateRequest struct {
-		Q      []string `json:"q"`
-		Target string   `json:"target"`
+	properNouns = nouns
+	properNounIndex = newProperNounMatcher(nouns)
+	return nil
+}
+
+// 加载术语表配置
+func loadGlossaryConfig(configPath string) error {
+	data, err := ioutil.ReadFile(configPath)
+	if err != nil {
+		// 术语表是可选的
+		return nil
 	}
 
-	payload := GoogleTranslateRequest{
-		Q:      batchTexts,
-		Target: mapLanguageCode(targetLang),
+	var config GlossaryConfig
+	if err := json.Unmarshal(data, &config); err != nil {
+		return fmt.Errorf("解析术语表配置失败: %v", err)
 	}
 
-	jsonData, _ := json.Marshal(payload)
+	sources := make([]string, 0, len(config.Terms))
+	for source := range config.Terms {
+		if strings.TrimSpace(source) == "" {
+			return fmt.Errorf("术语表中存在空术语")
+		}
+		sources = append(sources, source)
+	}
+	sort.Strings(sources)
 
-	req, _ := http.NewRequest("POST", requestURL, bytes.NewBuffer(jsonData))
-	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("User-Agent", "FluxReve-Translator/1.0")
+	glossary = config
+	glossarySources = sources
+	glossaryMatcher = newACMatcher(sources)
+	fmt.Printf("✅ 成功加载 %d 个术语\n", len(config.Terms))
+	return nil
+}
 
-	// 发送请求
-	client := &http.Client{Timeout: 30 * time.Second}
-	resp, err := client.Do(req)
-	if err != nil {
-		return nil, fmt.Errorf("网络错误: %v", err)
+// 检查术语表中的语言（目录名，如 "de"、"zh-CN"）是否对应目标语言代码
+func glossaryLocaleMatches(locale, targetLang string) bool {
+	if strings.EqualFold(locale, targetLang) {
+		return true
 	}
-	defer resp.Body.Close()
-
-	body, _ := ioutil.ReadAll(resp.Body)
+	info, err := resolveLocale(locale)
+	return err == nil && strings.EqualFold(info.Code, targetLang)
+}
 
-	// 检查响应状态码
-	if resp.StatusCode == 401 {
-		return nil, fmt.Errorf("API 验证失败 (401): 检查 API 密钥是否正确")
+// 目标语言的术语列表（按长度和字母排序，保证服务端术语表内容稳定）
+func glossaryFor(targetLang string) []glossaryTerm {
+	terms := []glossaryTerm{}
+	for source, targets := range glossary.Terms {
+		for locale, target := range targets {
+			if target != "" && glossaryLocaleMatches(locale, targetLang) {
+				terms = append(terms, glossaryTerm{Source: source, Target: target})
+				break
+			}
+		}
 	}
-	if resp.StatusCode == 429 {
-		return nil, fmt.Errorf("触发速率限制 (429)")
+	sort.Slice(terms, func(i, j int) bool {
+		if len(terms[i].Source) != len(terms[j].Source) {
+			return len(terms[i].Source) > len(terms[j].Source)
+		}
+		return terms[i].Source < terms[j].Source
+	})
+	return terms
+}
+
+// 转换为客户端替换使用的映射：英文术语 -> 规定译法
+func glossarySubstitutions(terms []glossaryTerm) map[string]string {
+	if len(terms) == 0 {
+		return nil
 	}
-	if resp.StatusCode != 200 {
-		return nil, fmt.Errorf("API 错误 (%d): %s", resp.StatusCode, string(body))
+	substitutions := make(map[string]string, len(terms))
+	for _, term := range terms {
+		substitutions[term.Source] = term.Target
 	}
+	return substitutions
+}
 
-	// 解析 Google API 响应
-	type GoogleTranslationData struct {
-		TranslatedText string `json:"translatedText"`
+// 原文中术语首字母大写（如句首的 "Credits"）而规定译法为小写时，译法首字母也大写
+func matchTermCase(matched, target string) string {
+	first, _ := utf8.DecodeRuneInString(matched)
+	targetFirst, size := utf8.DecodeRuneInString(target)
+	if unicode.IsUpper(first) && unicode.IsLower(targetFirst) {
+		return string(unicode.ToUpper(targetFirst)) + target[size:]
 	}
-	type GoogleTranslateResponse struct {
-		Data struct {
-			Translations []GoogleTranslationData `json:"translations"`
-		} `json:"data"`
+	return target
+}
+
+// 长度限制配置：SEO 元数据、按钮标签等空间有限的文本
+type LengthRulesConfig struct {
+	Description string       `json:"description"`
+	Rules       []LengthRule `json:"rules"`
+}
+
+// 单条长度限制
+// key 为键路径模式：可用 "命名空间:" 前缀限定文件（如 "pricing:billing.discount"），* 匹配一段，** 匹配任意多段
+type LengthRule struct {
+	Key  string `json:"key"`
+	Max  int    `json:"max"`
+	Unit string `json:"unit,omitempty"`
+}
+
+// 长度单位
+const (
+	lengthUnitChars = "chars" // 字符数（默认）
+	lengthUnitWidth = "width" // 显示宽度，中日韩等全角字符计为 2
+)
+
+// 已加载的长度限制（按配置顺序，第一条匹配的规则生效）
+var lengthRules []LengthRule
+
+// 加载长度限制配置
+func loadLengthRulesConfig(configPath string) error {
+	data, err := ioutil.ReadFile(configPath)
+	if err != nil {
+		// 长度限制是可选的
+		return nil
 	}
 
-	var googleResult GoogleTranslateResponse
-	if err := json.Unmarshal(body, &googleResult); err != nil {
-		return nil, fmt.Errorf("响应解析失败: %v", err)
+	var config LengthRulesConfig
+	if err := json.Unmarshal(data, &config); err != nil {
+		return fmt.Errorf("解析长度限制配置失败: %v", err)
+	}
+	for i, rule := range config.Rules {
+		if rule.Key == "" || rule.Max <= 0 {
+			return fmt.Errorf("长度限制 #%d 缺少 key 或 max", i+1)
+		}
+		switch rule.Unit {
+		case "":
+			config.Rules[i].Unit = lengthUnitChars
+		case lengthUnitChars, lengthUnitWidth:
+		default:
+			return fmt.Errorf("长度限制 %s 的单位无效: %s (可选: chars, width)", rule.Key, rule.Unit)
+		}
 	}
 
-	if len(googleResult.Data.Translations) == 0 {
-		return nil, fmt.Errorf("没有返回翻译结果")
+	lengthRules = config.Rules
+	fmt.Printf("✅ 成功加载 %d 条长度限制\n", len(lengthRules))
+	return nil
+}
+
+// 判断规则是否适用于命名空间（文件名去掉 .json）中的键路径
+func (r LengthRule) matches(namespace, path string) bool {
+	pattern := r.Key
+	if i := strings.Index(pattern, ":"); i >= 0 {
+		if pattern[:i] != namespace {
+			return false
+		}
+		pattern = pattern[i+1:]
 	}
+	return matchKeyPattern(strings.Split(pattern, "."), strings.Split(path, "."))
+}
 
-	// 转换为字符串数组
-	translations := make([]string, len(googleResult.Data.Translations))
-	for i, t := range googleResult.Data.Translations {
-		translations[i] = t.TranslatedText
+// 按段匹配键路径，* 匹配一段，** 匹配零到多段
+func matchKeyPattern(pattern, path []string) bool {
+	if len(pattern) == 0 {
+		return len(path) == 0
+	}
+	if pattern[0] == "**" {
+		for i := 0; i <= len(path); i++ {
+			if matchKeyPattern(pattern[1:], path[i:]) {
+				return true
+			}
+		}
+		return false
 	}
+	if len(path) == 0 || (pattern[0] != "*" && pattern[0] != path[0]) {
+		return false
+	}
+	return matchKeyPattern(pattern[1:], path[1:])
+}
 
-	return translations, nil
+// 键路径适用的长度限制，没有时返回 nil
+func lengthRuleFor(namespace, path string) *LengthRule {
+	for i := range lengthRules {
+		if lengthRules[i].matches(namespace, path) {
+			return &lengthRules[i]
+		}
+	}
+	return nil
 }
 
-// 批量调用 Google Cloud Translation API 翻译文本
-func translateWithGoogleBatch(apiKey string, texts []string, targetLang string, fileCache map[string]CacheEntry) (map[string]string, error) {
-	// 分离需要翻译和已缓存的文本
-	toTranslate := []string{}
-	toTranslateOriginals := []string{} // 保存原始文本（包含占位符）
-	toTranslateProtectedMaps := []map[string]string{} // 保存每个文本的 keep 内容映射
-	results := make(map[string]string)
+// 文本长度：富文本标签不显示，不计入长度
+func (r LengthRule) measure(text string) int {
+	text = richTagRegex.ReplaceAllString(text, "")
+	if r.Unit == lengthUnitWidth {
+		return displayWidth(text)
+	}
+	return utf8.RuneCountInString(text)
+}
 
-	// 为这个批次创建占位符生成器
-	placeholderGen := NewPlaceholderGenerator()
+// 长度限制的描述（用于 LLM 提示词）
+func (r LengthRule) describe() string {
+	if r.Unit == lengthUnitWidth {
+		return fmt.Sprintf("at most %d display columns (CJK and other full-width characters count as 2)", r.Max)
+	}
+	return fmt.Sprintf("at most %d characters", r.Max)
+}
 
-	for _, text := range texts {
-		if len(text) == 0 {
-			results[text] = text
-			continue
+// 显示宽度：全角字符（中日韩文字、全角标点等）计为 2，组合附加符号计为 0
+func displayWidth(text string) int {
+	width := 0
+	for _, r := range text {
+		switch {
+		case unicode.Is(unicode.Mn, r) || r == '\u200b' || r == '\u200d':
+		case isWideRune(r):
+			width += 2
+		default:
+			width++
 		}
+	}
+	return width
+}
 
-		// 如果是纯占位符（如 "{name}"），直接跳过翻译
-		if isPlaceholder(text) {
-			results[text] = text
-			continue
-		}
+// 是否为全角字符（近似 Unicode East Asian Width 的 W/F 类）
+func isWideRune(r rune) bool {
+	return unicode.In(r, unicode.Han, unicode.Hiragana, unicode.Katakana, unicode.Hangul) ||
+		(r >= 0x1100 && r <= 0x115F) || // 谚文字母
+		(r >= 0x2E80 && r <= 0x303E) || // 中日韩部首、标点
+		(r >= 0x3041 && r <= 0x33FF) || // 假名、注音、中日韩兼容字符
+		(r >= 0xFE30 && r <= 0xFE4F) || // 中日韩兼容形式
+		(r >= 0xFF00 && r <= 0xFF60) || // 全角 ASCII
+		(r >= 0xFFE0 && r <= 0xFFE6) || // 全角符号
+		(r >= 0x1F300 && r <= 0x1F64F) || // 表情符号
+		(r >= 0x1F900 && r <= 0x1F9FF)
+}
 
-		// 检查内存缓存
-		if cached, ok := translationCache[text]; ok {
-			cacheHits++
-			results[text] = cached
-			continue
-		}
+// 超出长度限制的译文
+type lengthViolation struct {
+	Rule   *LengthRule
+	Length int
+}
 
-		// 检查磁盘缓存（未过期）
-		if entry, ok := fileCache[text]; ok && !isCacheExpired(entry.Timestamp) {
-			cacheHits++
-			translationCache[text] = entry.Translation
-			results[text] = entry.Translation
+// 检查一组译文的长度，返回超出限制的原文（同一原文用于多个键时取最严格的限制）
+func findOverLength(texts *TextCollection, translations map[string]string, namespace string) map[string]lengthViolation {
+	violations := make(map[string]lengthViolation)
+	for _, text := range texts.Order {
+		translated, ok := translations[text]
+		if !ok {
 			continue
 		}
+		for _, path := range texts.KeyPaths[text] {
+			rule := lengthRuleFor(namespace, path)
+			if rule == nil {
+				continue
+			}
+			length := rule.measure(translated)
+			if length <= rule.Max {
+				continue
+			}
+			if current, ok := violations[text]; !ok || rule.Max < current.Rule.Max {
+				violations[text] = lengthViolation{Rule: rule, Length: length}
+			}
+		}
+	}
+	return violations
+}
 
-		// 保存原始文本
-		toTranslateOriginals = append(toTranslateOriginals, text)
+// 超出限制的原因
+func (v lengthViolation) String() string {
+	return fmt.Sprintf("长度 %d 超过限制 %d (%s, 规则 %s)", v.Length, v.Rule.Max, v.Rule.Unit, v.Rule.Key)
+}
 
-		// 客户端处理：将占位符和专有名词替换为特殊标记，这样 DeepL 完全不会翻译它们
-		textToTranslate, protectedMap := protectAllContentWithGenerator(text, placeholderGen)
-		toTranslate = append(toTranslate, textToTranslate)
-		toTranslateProtectedMaps = append(toTranslateProtectedMaps, protectedMap)
+// 翻译服务接口：不同厂商（Google、DeepL 等）各自实现，由 -provider 参数选择
+type Translator interface {
+	// 服务名称（用于日志输出）
+	Name() string
+	// 翻译单批文本，返回结果与输入顺序一一对应
+	TranslateBatch(texts []string, targetLang string) ([]string, error)
+	// 单次请求允许的最大文本数量
+	MaxBatchSize() int
+	// 支持的目标语言（内部语言代码，如 DE、ZH、PT-BR）
+	SupportedLanguages() []string
+}
+
+// 支持上下文的翻译服务（如 LLM）：除文本外还接收每个文本的 JSON 键路径
+type ContextualTranslator interface {
+	Translator
+	TranslateBatchWithContext(texts []string, keyPaths [][]string, targetLang string) ([]string, error)
+}
+
+// 支持缩短译文的翻译服务（如 LLM）：译文超出长度限制时，按每条的限制说明重写
+type ShorteningTranslator interface {
+	Translator
+	ShortenBatch(texts []string, limits []string, keyPaths [][]string, targetLang string) ([]string, error)
+}
+
+// LLM 翻译服务配置（OpenAI 兼容接口）
+type LLMConfig struct {
+	BaseURL string
+	Model   string
+}
+
+// 根据名称创建翻译服务
+func newTranslator(provider, apiKey string, llmConfig LLMConfig) (Translator, error) {
+	switch strings.ToLower(provider) {
+	case "google":
+		return NewGoogleTranslator(apiKey), nil
+	case "deepl":
+		return NewDeepLTranslator(apiKey), nil
+	case "openai":
+		return NewOpenAITranslator(apiKey, llmConfig.BaseURL, llmConfig.Model), nil
+	case "opencc":
+		return NewChineseConverter(openCCDictDir)
+	default:
+		return nil, fmt.Errorf("不支持的翻译服务: %s (可选: google, deepl, openai, opencc)", provider)
 	}
+}
 
-	// 如果没有需要翻译的文本，直接返回
-	if len(toTranslate) == 0 {
-		return results, nil
+// 支持服务端术语表的翻译服务（DeepL 术语表 API、Google v3 术语表）
+// PrepareGlossary 返回 true 表示该语言的术语由服务端强制使用，客户端不再替换
+type GlossaryTranslator interface {
+	Translator
+	PrepareGlossary(targetLang string, terms []glossaryTerm) bool
+}
+
+// 检查翻译服务是否支持目标语言
+func supportsLanguage(translator Translator, lang string) bool {
+	for _, supported := range translator.SupportedLanguages() {
+		if strings.EqualFold(supported, lang) {
+			return true
+		}
 	}
+	return false
+}
 
-	// Google Cloud Translation API 有限制：最多 128 个文本/请求
-	const maxBatchSize = 128
-	type TranslationItem struct {
-		Text string `json:"text"`
+// 翻译服务返回的 HTTP 错误
+type APIError struct {
+	StatusCode int
+	RetryAfter time.Duration // 服务端通过 Retry-After 要求的等待时间（0 表示未指定）
+	Message    string
+}
+
+func (e *APIError) Error() string {
+	return e.Message
+}
+
+// 检查响应状态码，非 200 时返回 *APIError
+func checkResponseStatus(resp *http.Response, body []byte) error {
+	if resp.StatusCode == 200 {
+		return nil
 	}
-	allTranslations := make([]TranslationItem, 0)
 
-	// 分批处理文本
-	for batchStart := 0; batchStart < len(toTranslate); batchStart += maxBatchSize {
-		batchEnd := batchStart + maxBatchSize
-		if batchEnd > len(toTranslate) {
-			batchEnd = len(toTranslate)
+	apiErr := &APIError{
+		StatusCode: resp.StatusCode,
+		RetryAfter: parseRetryAfter(resp.Header.Get("Retry-After")),
+	}
+	switch resp.StatusCode {
+	case 401, 403:
+		apiErr.Message = fmt.Sprintf("API 验证失败 (%d): 检查 API 密钥是否正确", resp.StatusCode)
+	case 429:
+		apiErr.Message = "触发速率限制 (429)"
+	case 456:
+		apiErr.Message = "翻译额度已用完 (456)"
+	default:
+		apiErr.Message = fmt.Sprintf("API 错误 (%d): %s", resp.StatusCode, string(body))
+	}
+	return apiErr
+}
+
+// 解析 Retry-After 响应头（秒数或 HTTP 日期）
+func parseRetryAfter(value string) time.Duration {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return 0
+	}
+	if seconds, err := strconv.Atoi(value); err == nil && seconds > 0 {
+		return time.Duration(seconds) * time.Second
+	}
+	if date, err := http.ParseTime(value); err == nil {
+		if wait := time.Until(date); wait > 0 {
+			return wait
 		}
-		batchTexts := toTranslate[batchStart:batchEnd]
+	}
+	return 0
+}
 
-		// 速率限制
-		elapsed := time.Since(lastRequestTime).Seconds()
-		if elapsed < 0.5 {
-			time.Sleep(time.Duration((0.5 - elapsed) * float64(time.Second)))
+// 重试策略：指数退避 + 随机抖动
+type RetryPolicy struct {
+	MaxAttempts int           // 最多尝试次数（包含第一次）
+	BaseDelay   time.Duration // 第一次重试的基础等待时间
+	MaxDelay    time.Duration // 单次等待时间上限
+}
+
+// 默认重试策略
+var retryPolicy = RetryPolicy{MaxAttempts: 5, BaseDelay: time.Second, MaxDelay: 30 * time.Second}
+
+// 计算第 attempt 次重试前的等待时间（attempt 从 1 开始）
+// 在 [d/2, d] 之间随机抖动（d = BaseDelay*2^(attempt-1)，不超过 MaxDelay），避免多个 worker 同时重试
+// 服务端指定了 Retry-After 时至少等待该时间
+func (p RetryPolicy) backoff(attempt int, err error) time.Duration {
+	delay := p.BaseDelay << uint(attempt-1)
+	if delay <= 0 || delay > p.MaxDelay {
+		delay = p.MaxDelay
+	}
+	delay = delay/2 + time.Duration(rand.Int63n(int64(delay/2)+1))
+
+	var apiErr *APIError
+	if errors.As(err, &apiErr) && apiErr.RetryAfter > delay {
+		delay = apiErr.RetryAfter
+	}
+	return delay
+}
+
+// 判断错误是否可以重试
+// 可重试：429、500、502、503、504 以及网络超时/连接中断
+// 不可重试：400、401、403、456 等（重试也不会成功）
+func isRetryable(err error) bool {
+	var apiErr *APIError
+	if errors.As(err, &apiErr) {
+		switch apiErr.StatusCode {
+		case 429, 500, 502, 503, 504:
+			return true
+		default:
+			return false
+		}
+	}
+
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return true
+	}
+	return errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, syscall.ECONNRESET)
+}
+
+// 按重试策略执行 fn，遇到可重试的错误时退避后重试
+func withRetry(policy RetryPolicy, description string, fn func() error) error {
+	var err error
+	for attempt := 1; ; attempt++ {
+		err = fn()
+		if err == nil || !isRetryable(err) || attempt >= policy.MaxAttempts {
+			break
+		}
+
+		delay := policy.backoff(attempt, err)
+		fmt.Printf("  ⏳ %s失败 (%v)，%.1f 秒后第 %d 次重试\n", description, err, delay.Seconds(), attempt)
+		time.Sleep(delay)
+	}
+	return err
+}
+
+// Google Cloud Translation API (v2) 翻译服务
+type GoogleTranslator struct {
+	apiKey  string
+	baseURL string // 接口地址（测试时替换为本地服务）
+	client  *http.Client
+
+	// v3 接口不接受 API 密钥，使用术语表时需要 OAuth 访问令牌（gcloud auth print-access-token）
+	accessToken string
+
+	glossaryMu sync.Mutex
+	glossaries map[string]string // 目标语言 -> v3 术语表资源名（空字符串表示不可用）
+}
+
+// 创建 Google 翻译服务
+func NewGoogleTranslator(apiKey string) *GoogleTranslator {
+	return &GoogleTranslator{
+		apiKey:     apiKey,
+		baseURL:    "https://translation.googleapis.com",
+		client:     &http.Client{Timeout: 30 * time.Second},
+		glossaries: make(map[string]string),
+	}
+}
+
+func (g *GoogleTranslator) Name() string {
+	return "Google Cloud Translation"
+}
+
+// Google Cloud Translation API 有限制：最多 128 个文本/请求
+func (g *GoogleTranslator) MaxBatchSize() int {
+	return 128
+}
+
+func (g *GoogleTranslator) SupportedLanguages() []string {
+	return registryCodes(func(info LocaleInfo) bool { return info.Google != "" })
+}
+
+// Google 在 HTML 模式下不会翻译 translate="no" 的元素
+func (g *GoogleTranslator) ProtectionMarkup() ProtectionMarkup {
+	return htmlMarkup{}
+}
+
+// Google v3 术语表需要预先在 Cloud 控制台创建，这里只查找配置中为该语言指定的术语表
+// 没有 OAuth 访问令牌时无法调用 v3 接口，退回客户端替换
+func (g *GoogleTranslator) PrepareGlossary(targetLang string, terms []glossaryTerm) bool {
+	g.glossaryMu.Lock()
+	defer g.glossaryMu.Unlock()
+
+	if resource, ok := g.glossaries[targetLang]; ok {
+		return resource != ""
+	}
+	resource := ""
+	for locale, candidate := range glossary.GoogleGlossaries {
+		if candidate != "" && glossaryLocaleMatches(locale, targetLang) {
+			resource = candidate
+			break
+		}
+	}
+	if resource != "" && g.accessToken == "" {
+		fmt.Printf("⚠️  Google v3 术语表需要 OAuth 访问令牌 (-google-token)，%s 改为客户端替换术语\n", targetLang)
+		resource = ""
+	}
+	g.glossaries[targetLang] = resource
+	return resource != ""
+}
+
+// 调用 Google Cloud Translation API 翻译单批文本（最多 128 个）
+// 该语言配置了 v3 术语表时改用 v3 接口
+func (g *GoogleTranslator) TranslateBatch(batchTexts []string, targetLang string) ([]string, error) {
+	g.glossaryMu.Lock()
+	glossaryResource := g.glossaries[targetLang]
+	g.glossaryMu.Unlock()
+	if glossaryResource != "" {
+		return g.translateWithGlossary(batchTexts, targetLang, glossaryResource)
+	}
+
+	locale, err := resolveLocale(targetLang)
+	if err != nil {
+		return nil, err
+	}
+
+	// Google Cloud Translation API 端点
+	requestURL := fmt.Sprintf("%s/language/translate/v2?key=%s", g.baseURL, g.apiKey)
+
+	// 构建请求体（HTML 模式，配合 translate="no" 保护占位符和专有名词）
+	type GoogleTranslateRequest struct {
+		Q      []string `json:"q"`
+		Target string   `json:"target"`
+		Source string   `json:"source"`
+		Format string   `json:"format"`
+	}
+
+	payload := GoogleTranslateRequest{
+		Q:      batchTexts,
+		Target: locale.Google,
+		Source: "en",
+		Format: "html",
+	}
+
+	jsonData, _ := json.Marshal(payload)
+
+	req, _ := http.NewRequest("POST", requestURL, bytes.NewBuffer(jsonData))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "FluxReve-Translator/1.0")
+
+	// 发送请求
+	resp, err := g.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("网络错误: %w", err)
+	}
+	defer resp.Body.Close()
+
+	body, _ := ioutil.ReadAll(resp.Body)
+
+	// 检查响应状态码
+	if err := checkResponseStatus(resp, body); err != nil {
+		return nil, err
+	}
+
+	// 解析 Google API 响应
+	type GoogleTranslationData struct {
+		TranslatedText string `json:"translatedText"`
+	}
+	type GoogleTranslateResponse struct {
+		Data struct {
+			Translations []GoogleTranslationData `json:"translations"`
+		} `json:"data"`
+	}
+
+	var googleResult GoogleTranslateResponse
+	if err := json.Unmarshal(body, &googleResult); err != nil {
+		return nil, fmt.Errorf("响应解析失败: %v", err)
+	}
+
+	if len(googleResult.Data.Translations) != len(batchTexts) {
+		return nil, fmt.Errorf("返回的翻译数量不匹配: 期望 %d，实际 %d", len(batchTexts), len(googleResult.Data.Translations))
+	}
+
+	// 转换为字符串数组
+	translations := make([]string, len(googleResult.Data.Translations))
+	for i, t := range googleResult.Data.Translations {
+		translations[i] = t.TranslatedText
+	}
+
+	return translations, nil
+}
+
+// 调用 Google Cloud Translation v3 接口，使用术语表翻译单批文本
+func (g *GoogleTranslator) translateWithGlossary(batchTexts []string, targetLang, glossaryResource string) ([]string, error) {
+	locale, err := resolveLocale(targetLang)
+	if err != nil {
+		return nil, err
+	}
+
+	// 术语表资源名为 projects/<p>/locations/<l>/glossaries/<id>，请求发往 projects/<p>/locations/<l>
+	parent := glossaryResource
+	if index := strings.Index(parent, "/glossaries/"); index >= 0 {
+		parent = parent[:index]
+	}
+	requestURL := fmt.Sprintf("%s/v3/%s:translateText", g.baseURL, parent)
+
+	type GoogleGlossaryConfig struct {
+		Glossary string `json:"glossary"`
+	}
+	type GoogleV3TranslateRequest struct {
+		Contents           []string             `json:"contents"`
+		MimeType           string               `json:"mimeType"`
+		SourceLanguageCode string               `json:"sourceLanguageCode"`
+		TargetLanguageCode string               `json:"targetLanguageCode"`
+		GlossaryConfig     GoogleGlossaryConfig `json:"glossaryConfig"`
+	}
+
+	payload := GoogleV3TranslateRequest{
+		Contents:           batchTexts,
+		MimeType:           "text/html",
+		SourceLanguageCode: "en",
+		TargetLanguageCode: locale.Google,
+		GlossaryConfig:     GoogleGlossaryConfig{Glossary: glossaryResource},
+	}
+
+	jsonData, _ := json.Marshal(payload)
+
+	// v3 只接受 OAuth 认证；用户凭据需要通过 x-goog-user-project 指定计费项目
+	req, _ := http.NewRequest("POST", requestURL, bytes.NewBuffer(jsonData))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "FluxReve-Translator/1.0")
+	req.Header.Set("Authorization", "Bearer "+g.accessToken)
+	if project := strings.Split(parent, "/"); len(project) > 1 && project[0] == "projects" {
+		req.Header.Set("x-goog-user-project", project[1])
+	}
+
+	resp, err := g.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("网络错误: %w", err)
+	}
+	defer resp.Body.Close()
+
+	body, _ := ioutil.ReadAll(resp.Body)
+
+	if err := checkResponseStatus(resp, body); err != nil {
+		return nil, err
+	}
+
+	// v3 同时返回普通译文和使用术语表的译文，这里只取后者
+	type GoogleV3TranslateResponse struct {
+		GlossaryTranslations []struct {
+			TranslatedText string `json:"translatedText"`
+		} `json:"glossaryTranslations"`
+	}
+
+	var result GoogleV3TranslateResponse
+	if err := json.Unmarshal(body, &result); err != nil {
+		return nil, fmt.Errorf("响应解析失败: %v", err)
+	}
+
+	if len(result.GlossaryTranslations) != len(batchTexts) {
+		return nil, fmt.Errorf("返回的翻译数量不匹配: 期望 %d，实际 %d", len(batchTexts), len(result.GlossaryTranslations))
+	}
+
+	translations := make([]string, len(result.GlossaryTranslations))
+	for i, t := range result.GlossaryTranslations {
+		translations[i] = t.TranslatedText
+	}
+	return translations, nil
+}
+
+// DeepL API 翻译服务
+type DeepLTranslator struct {
+	apiKey  string
+	baseURL string // 接口地址（测试时替换为本地服务）
+	client  *http.Client
+
+	glossaryMu sync.Mutex
+	glossaries map[string]string // 目标语言 -> glossary_id（空字符串表示不可用）
+}
+
+// 创建 DeepL 翻译服务
+// 免费版密钥以 ":fx" 结尾，需要使用 api-free 域名
+func NewDeepLTranslator(apiKey string) *DeepLTranslator {
+	baseURL := "https://api.deepl.com"
+	if strings.HasSuffix(apiKey, ":fx") {
+		baseURL = "https://api-free.deepl.com"
+	}
+	return &DeepLTranslator{
+		apiKey:     apiKey,
+		baseURL:    baseURL,
+		client:     &http.Client{Timeout: 30 * time.Second},
+		glossaries: make(map[string]string),
+	}
+}
+
+func (d *DeepLTranslator) Name() string {
+	return "DeepL"
+}
+
+// DeepL API 有限制：最多 50 个文本/请求
+func (d *DeepLTranslator) MaxBatchSize() int {
+	return 50
+}
+
+func (d *DeepLTranslator) SupportedLanguages() []string {
+	return registryCodes(func(info LocaleInfo) bool { return info.DeepL != "" })
+}
+
+// DeepL 在 XML 模式下不会翻译 ignore_tags 中的标签
+func (d *DeepLTranslator) ProtectionMarkup() ProtectionMarkup {
+	return xmlMarkup{}
+}
+
+// 为目标语言准备 DeepL 术语表（每种语言只准备一次）
+// 术语表按内容命名，内容不变时复用账户中已有的术语表，失败时退回客户端替换
+func (d *DeepLTranslator) PrepareGlossary(targetLang string, terms []glossaryTerm) bool {
+	d.glossaryMu.Lock()
+	defer d.glossaryMu.Unlock()
+
+	if id, ok := d.glossaries[targetLang]; ok {
+		return id != ""
+	}
+	id, err := d.ensureGlossary(targetLang, terms)
+	if err != nil {
+		fmt.Printf("⚠️  DeepL 术语表不可用 (%s): %v，改为客户端替换术语\n", targetLang, err)
+	}
+	d.glossaries[targetLang] = id
+	return id != ""
+}
+
+// 查找或创建 DeepL 术语表，返回 glossary_id
+func (d *DeepLTranslator) ensureGlossary(targetLang string, terms []glossaryTerm) (string, error) {
+	locale, err := resolveLocale(targetLang)
+	if err != nil {
+		return "", err
+	}
+
+	// 术语表语言使用不带地区的代码（如 zh、pt、nb）
+	glossaryLang := strings.ToLower(strings.SplitN(locale.DeepL, "-", 2)[0])
+
+	var entries strings.Builder
+	for _, term := range terms {
+		if strings.ContainsAny(term.Source+term.Target, "\t\n") {
+			continue
+		}
+		entries.WriteString(term.Source + "\t" + term.Target + "\n")
+	}
+	name := fmt.Sprintf("fluxreve-%s-%s", glossaryLang, sourceHash(entries.String())[:8])
+	glossariesURL := d.baseURL + "/v2/glossaries"
+
+	// 复用同名术语表
+	var list struct {
+		Glossaries []struct {
+			GlossaryID string `json:"glossary_id"`
+			Name       string `json:"name"`
+		} `json:"glossaries"`
+	}
+	if err := d.glossaryRequest("GET", glossariesURL, nil, &list); err != nil {
+		return "", err
+	}
+	for _, existing := range list.Glossaries {
+		if existing.Name == name {
+			return existing.GlossaryID, nil
+		}
+	}
+
+	payload := map[string]string{
+		"name":           name,
+		"source_lang":    "en",
+		"target_lang":    glossaryLang,
+		"entries":        entries.String(),
+		"entries_format": "tsv",
+	}
+	var created struct {
+		GlossaryID string `json:"glossary_id"`
+	}
+	if err := d.glossaryRequest("POST", glossariesURL, payload, &created); err != nil {
+		return "", err
+	}
+	fmt.Printf("📘 已创建 DeepL 术语表: %s (%d 个术语)\n", name, len(terms))
+	return created.GlossaryID, nil
+}
+
+// 调用 DeepL 术语表接口
+func (d *DeepLTranslator) glossaryRequest(method, requestURL string, payload interface{}, result interface{}) error {
+	var reqBody io.Reader
+	if payload != nil {
+		jsonData, _ := json.Marshal(payload)
+		reqBody = bytes.NewBuffer(jsonData)
+	}
+
+	req, _ := http.NewRequest(method, requestURL, reqBody)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "DeepL-Auth-Key "+d.apiKey)
+	req.Header.Set("User-Agent", "FluxReve-Translator/1.0")
+
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("网络错误: %w", err)
+	}
+	defer resp.Body.Close()
+
+	body, _ := ioutil.ReadAll(resp.Body)
+	if err := checkResponseStatus(resp, body); err != nil {
+		return err
+	}
+	if err := json.Unmarshal(body, result); err != nil {
+		return fmt.Errorf("响应解析失败: %v", err)
+	}
+	return nil
+}
+
+// 调用 DeepL API 翻译单批文本（最多 50 个）
+func (d *DeepLTranslator) TranslateBatch(batchTexts []string, targetLang string) ([]string, error) {
+	locale, err := resolveLocale(targetLang)
+	if err != nil {
+		return nil, err
+	}
+
+	// 构建请求体（XML 模式，<x> 标签内的内容不翻译）
+	type DeepLTranslateRequest struct {
+		Text        []string `json:"text"`
+		SourceLang  string   `json:"source_lang"`
+		TargetLang  string   `json:"target_lang"`
+		TagHandling string   `json:"tag_handling"`
+		IgnoreTags  []string `json:"ignore_tags"`
+		GlossaryID  string   `json:"glossary_id,omitempty"`
+	}
+
+	d.glossaryMu.Lock()
+	glossaryID := d.glossaries[targetLang]
+	d.glossaryMu.Unlock()
+
+	payload := DeepLTranslateRequest{
+		Text:        batchTexts,
+		SourceLang:  "EN",
+		TargetLang:  locale.DeepL,
+		TagHandling: "xml",
+		IgnoreTags:  []string{"x"},
+		GlossaryID:  glossaryID,
+	}
+
+	jsonData, _ := json.Marshal(payload)
+
+	req, _ := http.NewRequest("POST", d.baseURL+"/v2/translate", bytes.NewBuffer(jsonData))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "DeepL-Auth-Key "+d.apiKey)
+	req.Header.Set("User-Agent", "FluxReve-Translator/1.0")
+
+	// 发送请求
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("网络错误: %w", err)
+	}
+	defer resp.Body.Close()
+
+	body, _ := ioutil.ReadAll(resp.Body)
+
+	// 检查响应状态码
+	if err := checkResponseStatus(resp, body); err != nil {
+		return nil, err
+	}
+
+	// 解析 DeepL API 响应
+	type DeepLTranslateResponse struct {
+		Translations []struct {
+			DetectedSourceLanguage string `json:"detected_source_language"`
+			Text                   string `json:"text"`
+		} `json:"translations"`
+	}
+
+	var deeplResult DeepLTranslateResponse
+	if err := json.Unmarshal(body, &deeplResult); err != nil {
+		return nil, fmt.Errorf("响应解析失败: %v", err)
+	}
+
+	if len(deeplResult.Translations) != len(batchTexts) {
+		return nil, fmt.Errorf("返回的翻译数量不匹配: 期望 %d，实际 %d", len(batchTexts), len(deeplResult.Translations))
+	}
+
+	translations := make([]string, len(deeplResult.Translations))
+	for i, t := range deeplResult.Translations {
+		translations[i] = t.Text
+	}
+
+	return translations, nil
+}
+
+// OpenAI 兼容的 Chat Completions 翻译服务
+// 适用于 OpenAI 以及任何兼容该接口的服务（可配置 base URL，便于本地替身服务测试）
+type OpenAITranslator struct {
+	apiKey  string
+	baseURL string
+	model   string
+	client  *http.Client
+}
+
+// 创建 LLM 翻译服务
+func NewOpenAITranslator(apiKey, baseURL, model string) *OpenAITranslator {
+	return &OpenAITranslator{
+		apiKey:  apiKey,
+		baseURL: strings.TrimSuffix(baseURL, "/"),
+		model:   model,
+		client:  &http.Client{Timeout: 120 * time.Second},
+	}
+}
+
+// 名称包含模型和接口地址，同时作为缓存键的一部分（不同模型/服务的译文互不复用）
+func (o *OpenAITranslator) Name() string {
+	host := o.baseURL
+	if parsed, err := url.Parse(o.baseURL); err == nil && parsed.Host != "" {
+		host = parsed.Host
+	}
+	return fmt.Sprintf("LLM (%s @ %s)", o.model, host)
+}
+
+// 每次请求的文本数量保持较小，避免输出被截断
+func (o *OpenAITranslator) MaxBatchSize() int {
+	return 40
+}
+
+func (o *OpenAITranslator) SupportedLanguages() []string {
+	return registryCodes(func(info LocaleInfo) bool { return true })
+}
+
+// 没有上下文时，键路径为空
+func (o *OpenAITranslator) TranslateBatch(batchTexts []string, targetLang string) ([]string, error) {
+	return o.TranslateBatchWithContext(batchTexts, make([][]string, len(batchTexts)), targetLang)
+}
+
+// 调用 Chat Completions 接口翻译单批文本
+func (o *OpenAITranslator) TranslateBatchWithContext(batchTexts []string, keyPaths [][]string, targetLang string) ([]string, error) {
+	// 用户消息：带编号和键路径的待翻译文本
+	type llmItem struct {
+		ID   int      `json:"id"`
+		Keys []string `json:"keys,omitempty"`
+		Text string   `json:"text"`
+	}
+	items := make([]llmItem, len(batchTexts))
+	for i, text := range batchTexts {
+		items[i] = llmItem{ID: i, Text: text}
+		if i < len(keyPaths) {
+			items[i].Keys = keyPaths[i]
+		}
+	}
+	return o.complete(buildLLMSystemPrompt(targetLang), items, len(batchTexts))
+}
+
+// 缩短超出长度限制的译文，每条带有自己的长度限制说明
+func (o *OpenAITranslator) ShortenBatch(batchTexts []string, limits []string, keyPaths [][]string, targetLang string) ([]string, error) {
+	type llmItem struct {
+		ID    int      `json:"id"`
+		Keys  []string `json:"keys,omitempty"`
+		Limit string   `json:"limit"`
+		Text  string   `json:"text"`
+	}
+	items := make([]llmItem, len(batchTexts))
+	for i, text := range batchTexts {
+		items[i] = llmItem{ID: i, Limit: limits[i], Text: text}
+		if i < len(keyPaths) {
+			items[i].Keys = keyPaths[i]
+		}
+	}
+	return o.complete(buildLLMShortenPrompt(targetLang), items, len(batchTexts))
+}
+
+// 发送一次 Chat Completions 请求，按编号返回模型输出的 count 条文本
+func (o *OpenAITranslator) complete(systemPrompt string, items interface{}, count int) ([]string, error) {
+	userContent, _ := json.Marshal(map[string]interface{}{"items": items})
+
+	type chatMessage struct {
+		Role    string `json:"role"`
+		Content string `json:"content"`
+	}
+	payload := map[string]interface{}{
+		"model": o.model,
+		"messages": []chatMessage{
+			{Role: "system", Content: systemPrompt},
+			{Role: "user", Content: string(userContent)},
+		},
+		"temperature":     0.2,
+		"response_format": map[string]string{"type": "json_object"},
+	}
+
+	jsonData, _ := json.Marshal(payload)
+
+	req, _ := http.NewRequest("POST", o.baseURL+"/chat/completions", bytes.NewBuffer(jsonData))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "FluxReve-Translator/1.0")
+	if o.apiKey != "" {
+		req.Header.Set("Authorization", "Bearer "+o.apiKey)
+	}
+
+	// 发送请求
+	resp, err := o.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("网络错误: %w", err)
+	}
+	defer resp.Body.Close()
+
+	body, _ := ioutil.ReadAll(resp.Body)
+
+	// 检查响应状态码
+	if err := checkResponseStatus(resp, body); err != nil {
+		return nil, err
+	}
+
+	// 解析 Chat Completions 响应
+	type ChatCompletionResponse struct {
+		Choices []struct {
+			Message chatMessage `json:"message"`
+		} `json:"choices"`
+	}
+
+	var chatResult ChatCompletionResponse
+	if err := json.Unmarshal(body, &chatResult); err != nil {
+		return nil, fmt.Errorf("响应解析失败: %v", err)
+	}
+	if len(chatResult.Choices) == 0 {
+		return nil, fmt.Errorf("没有返回翻译结果")
+	}
+
+	// 模型输出为 JSON：{"translations": [{"id": 0, "text": "..."}]}
+	content := stripCodeFence(chatResult.Choices[0].Message.Content)
+	var llmResult struct {
+		Translations []struct {
+			ID   int    `json:"id"`
+			Text string `json:"text"`
+		} `json:"translations"`
+	}
+	if err := json.Unmarshal([]byte(content), &llmResult); err != nil {
+		return nil, fmt.Errorf("模型输出不是有效的 JSON: %v", err)
+	}
+
+	// 按编号还原顺序，缺失任何一条都视为失败
+	translations := make([]string, count)
+	filled := make([]bool, count)
+	for _, t := range llmResult.Translations {
+		if t.ID < 0 || t.ID >= count {
+			continue
+		}
+		translations[t.ID] = t.Text
+		filled[t.ID] = true
+	}
+	for i, ok := range filled {
+		if !ok {
+			return nil, fmt.Errorf("模型输出缺少编号 %d 的翻译", i)
+		}
+	}
+
+	return translations, nil
+}
+
+// 目标语言的英文名称和 BCP-47 标签（用于 LLM 提示词）
+func llmLanguage(targetLang string) (string, string) {
+	if info, err := resolveLocale(targetLang); err == nil {
+		return info.Name, info.Tag
+	}
+	return targetLang, targetLang
+}
+
+// 构建 LLM 系统提示词：目标语言、专有名词列表以及输出格式要求
+func buildLLMSystemPrompt(targetLang string) string {
+	languageName, localeTag := llmLanguage(targetLang)
+
+	var sb strings.Builder
+	sb.WriteString("You are a professional localizer for FluxReve, an AI image generation web app.\n")
+	sb.WriteString(fmt.Sprintf("Translate each item from English into %s (locale code: %s).\n", languageName, localeTag))
+	sb.WriteString("Keep the tone of the original marketing and UI copy: natural, concise and friendly, not a literal word-by-word translation.\n")
+	sb.WriteString("Each item carries the JSON key paths where the string is used (e.g. \"meta.title\", \"tiers.pro.description\"); use them as context for length and register.\n")
+	sb.WriteString("Rules:\n")
+	sb.WriteString("- Never translate or alter placeholder tokens such as ⟦1⟧, ⟪2⟫, {name} or {count}; keep every token exactly once, moving it only where the grammar requires.\n")
+	sb.WriteString("- Some tokens stand for opening and closing rich-text tags; keep each pair in order around the words they wrap.\n")
+	if terms := properNounTerms(); len(terms) > 0 {
+		sb.WriteString("- Never translate these proper nouns, keep them verbatim: ")
+		sb.WriteString(strings.Join(terms, ", "))
+		sb.WriteString("\n")
+	}
+	sb.WriteString("- Do not add explanations, quotes or extra punctuation.\n")
+	sb.WriteString("Respond with JSON only, in the form {\"translations\": [{\"id\": <id>, \"text\": \"<translation>\"}]}, with exactly one entry per input id.")
+	return sb.String()
+}
+
+// 构建缩短译文的系统提示词
+func buildLLMShortenPrompt(targetLang string) string {
+	languageName, _ := llmLanguage(targetLang)
+
+	var sb strings.Builder
+	sb.WriteString("You are a professional localizer for FluxReve, an AI image generation web app.\n")
+	sb.WriteString(fmt.Sprintf("Each item is a %s UI or SEO string that is too long for the space it is shown in.\n", languageName))
+	sb.WriteString("Rewrite each item in the same language so that it fits its \"limit\", keeping the meaning and the most important words; prefer shorter synonyms over dropping information.\n")
+	sb.WriteString("Rules:\n")
+	sb.WriteString("- Never translate or alter placeholder tokens such as ⟦1⟧, ⟪2⟫, {name} or {count}; keep every token exactly once.\n")
+	sb.WriteString("- Do not add explanations, quotes or extra punctuation.\n")
+	sb.WriteString("Respond with JSON only, in the form {\"translations\": [{\"id\": <id>, \"text\": \"<shortened text>\"}]}, with exactly one entry per input id.")
+	return sb.String()
+}
+
+// 专有名词的字面写法（正则条目不适合直接展示给模型，匹配到的内容已经被保护）
+func properNounTerms() []string {
+	terms := []string{}
+	for _, noun := range properNouns {
+		if noun.Match != matchRegex {
+			terms = append(terms, noun.Term)
+		}
+	}
+	return terms
+}
+
+// 去掉模型输出中可能包裹的 Markdown 代码块标记
+func stripCodeFence(content string) string {
+	content = strings.TrimSpace(content)
+	if !strings.HasPrefix(content, "```") {
+		return content
+	}
+	content = strings.TrimPrefix(content, "```json")
+	content = strings.TrimPrefix(content, "```")
+	content = strings.TrimSuffix(content, "```")
+	return strings.TrimSpace(content)
+}
+
+// 简繁转换词典目录（OpenCC 格式）
+const openCCDictDir = "./config/opencc"
+
+// 简繁转换的各个阶段，按顺序应用：每个阶段的词典文件合并后做最长匹配
+var openCCStages = [][]string{
+	{"STPhrases.txt", "STCharacters.txt"},
+	{"TWPhrases.txt"},
+	{"TWVariants.txt"},
+}
+
+// 单个转换阶段：词条 -> 替换文本，maxLen 为最长词条的字符数
+type conversionStage struct {
+	entries map[string]string
+	maxLen  int
+}
+
+// 离线简繁转换服务：把已审校的简体中文（messages/zh-CN）转换为繁体中文（台湾用词）
+// 使用 config/opencc 下的词典，不发送任何网络请求
+type ChineseConverter struct {
+	stages []*conversionStage
+	digest string
+}
+
+// 加载词典创建简繁转换服务
+func NewChineseConverter(dictDir string) (*ChineseConverter, error) {
+	hash := sha256.New()
+	converter := &ChineseConverter{}
+	for _, files := range openCCStages {
+		stage := &conversionStage{entries: make(map[string]string)}
+		for _, name := range files {
+			data, err := ioutil.ReadFile(filepath.Join(dictDir, name))
+			if err != nil {
+				return nil, fmt.Errorf("读取简繁转换词典失败: %v", err)
+			}
+			hash.Write(data)
+			if err := stage.load(data); err != nil {
+				return nil, fmt.Errorf("解析 %s 失败: %v", name, err)
+			}
+		}
+		converter.stages = append(converter.stages, stage)
+	}
+	converter.digest = hex.EncodeToString(hash.Sum(nil))[:8]
+	return converter, nil
+}
+
+// 解析 OpenCC 词典：每行 "词条<Tab>替换 [候选...]"，只取第一个候选；# 开头为注释
+// 同一阶段的多个文件中先出现的词条优先
+func (s *conversionStage) load(data []byte) error {
+	for i, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimRight(line, "\r")
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.SplitN(line, "\t", 2)
+		if len(fields) != 2 || fields[0] == "" || strings.TrimSpace(fields[1]) == "" {
+			return fmt.Errorf("第 %d 行格式错误: %q", i+1, line)
+		}
+		if _, exists := s.entries[fields[0]]; exists {
+			continue
+		}
+		s.entries[fields[0]] = strings.Fields(fields[1])[0]
+		if n := utf8.RuneCountInString(fields[0]); n > s.maxLen {
+			s.maxLen = n
+		}
+	}
+	return nil
+}
+
+// 从左到右做最长匹配替换，没有匹配的字符原样保留
+func (s *conversionStage) convert(text string) string {
+	runes := []rune(text)
+	var sb strings.Builder
+	for i := 0; i < len(runes); {
+		longest := s.maxLen
+		if rest := len(runes) - i; rest < longest {
+			longest = rest
+		}
+		matched := false
+		for n := longest; n > 0; n-- {
+			if replacement, ok := s.entries[string(runes[i:i+n])]; ok {
+				sb.WriteString(replacement)
+				i += n
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			sb.WriteRune(runes[i])
+			i++
+		}
+	}
+	return sb.String()
+}
+
+// 依次应用所有阶段
+func (c *ChineseConverter) Convert(text string) string {
+	for _, stage := range c.stages {
+		text = stage.convert(text)
+	}
+	return text
+}
+
+// 名称包含词典摘要，同时作为缓存键的一部分（词典修改后不会复用旧的转换结果）
+func (c *ChineseConverter) Name() string {
+	return fmt.Sprintf("OpenCC (s2twp, %s)", c.digest)
+}
+
+// 本地转换没有请求大小限制
+func (c *ChineseConverter) MaxBatchSize() int {
+	return 1000
+}
+
+// 只能转换为繁体中文
+func (c *ChineseConverter) SupportedLanguages() []string {
+	return registryCodes(func(info LocaleInfo) bool { return info.Tag == "zh-Hant" })
+}
+
+// 受保护内容已替换为哨兵标记，转换只改动其中的汉字
+func (c *ChineseConverter) TranslateBatch(batchTexts []string, targetLang string) ([]string, error) {
+	results := make([]string, len(batchTexts))
+	for i, text := range batchTexts {
+		results[i] = c.Convert(text)
+	}
+	return results, nil
+}
+
+// 批量调用翻译服务翻译文本（自动处理缓存、分批与速率限制）
+// keyPaths 记录每个文本所在的 JSON 键路径，供支持上下文的翻译服务使用
+// stats 为该任务所属语言的统计（可为 nil）
+// 校验失败的文本不写入结果和缓存，失败原因记录到 failures（原文 -> 原因，可为 nil）
+func translateBatch(translator Translator, texts []string, keyPaths map[string][]string, targetLang string, fileCache map[string]CacheEntry, stats *TranslationStats, failures map[string]string) (map[string]string, error) {
+	// 分离需要翻译和已缓存的文本
+	toTranslate := []string{}
+	toTranslateOriginals := []string{}                 // 保存原始文本（包含占位符）
+	toTranslateGenerators := []*PlaceholderGenerator{} // 保存每个文本的受保护内容
+	toTranslateKeyPaths := [][]string{}                // 保存每个文本的键路径
+	results := make(map[string]string)
+
+	// 术语表：服务端支持时由翻译服务强制使用，否则在客户端保护并替换为规定译法
+	terms := glossaryFor(targetLang)
+	substitutions := glossarySubstitutions(terms)
+	if gt, ok := translator.(GlossaryTranslator); ok && len(terms) > 0 && gt.PrepareGlossary(targetLang, terms) {
+		substitutions = nil
+	}
+
+	for _, text := range texts {
+		if len(text) == 0 {
+			results[text] = text
+			continue
+		}
+
+		// 如果是纯占位符（如 "{name}"），直接跳过翻译
+		if isPlaceholder(text) {
+			results[text] = text
+			continue
+		}
+
+		// 检查内存缓存（按翻译服务、目标语言、保护配置和原文区分）
+		key := cacheKey{Provider: translator.Name(), Lang: targetLang, Protection: protectionFingerprint, Text: text}
+		if cached, ok := getCachedTranslation(key); ok {
+			recordStats(stats, 0, 1, 0)
+			results[text] = cached
+			continue
+		}
+
+		// 检查磁盘缓存（按原文内容命中，原文变化后自然不会命中）
+		if entry, ok := fileCache[key.String()]; ok {
+			recordStats(stats, 0, 1, 0)
+			putCachedTranslation(key, entry.Translation)
+			results[text] = entry.Translation
+			continue
+		}
+
+		// 保存原始文本
+		toTranslateOriginals = append(toTranslateOriginals, text)
+
+		// 客户端处理：将占位符和专有名词替换为特殊标记，这样翻译服务完全不会翻译它们
+		placeholderGen := NewPlaceholderGenerator(markupFor(translator, text))
+		placeholderGen.Glossary = substitutions
+		textToTranslate, _ := protectAllContentWithGenerator(text, placeholderGen)
+		toTranslate = append(toTranslate, textToTranslate)
+		toTranslateGenerators = append(toTranslateGenerators, placeholderGen)
+		toTranslateKeyPaths = append(toTranslateKeyPaths, keyPaths[text])
+	}
+
+	// 如果没有需要翻译的文本，直接返回
+	if len(toTranslate) == 0 {
+		return results, nil
+	}
+
+	// 每个翻译服务的单次请求文本数量上限不同
+	maxBatchSize := translator.MaxBatchSize()
+	type TranslationItem struct {
+		Text string `json:"text"`
+	}
+	allTranslations := make([]TranslationItem, 0)
+
+	// 分批处理文本
+	for batchStart := 0; batchStart < len(toTranslate); batchStart += maxBatchSize {
+		batchEnd := batchStart + maxBatchSize
+		if batchEnd > len(toTranslate) {
+			batchEnd = len(toTranslate)
+		}
+		batchTexts := toTranslate[batchStart:batchEnd]
+
+		// 调用单批翻译函数（429、5xx 和网络超时会按重试策略退避重试）
+		// 支持上下文的翻译服务（如 LLM）额外传入键路径
+		var batchResults []string
+		err := withRetry(retryPolicy, "翻译批次", func() error {
+			// 速率限制（所有 worker 共享令牌桶，每次重试也要重新获取令牌）
+			rateLimiter.Wait()
+			recordStats(stats, 1, 0, 0)
+
+			var err error
+			if ct, ok := translator.(ContextualTranslator); ok {
+				batchResults, err = ct.TranslateBatchWithContext(batchTexts, toTranslateKeyPaths[batchStart:batchEnd], targetLang)
+			} else {
+				batchResults, err = translator.TranslateBatch(batchTexts, targetLang)
+			}
+			return err
+		})
+		if err != nil {
+			return nil, fmt.Errorf("翻译批次失败: %v", err)
+		}
+
+		// 累加结果
+		for _, text := range batchResults {
+			allTranslations = append(allTranslations, TranslationItem{Text: text})
+		}
+
+		fmt.Printf("  ✓ 已处理批次: %d/%d\n", batchEnd, len(toTranslate))
+	}
+
+	// 构建兼容格式的结果
+	type CompatibleResponse struct {
+		Translations []TranslationItem `json:"translations"`
+	}
+	result := CompatibleResponse{
+		Translations: allTranslations,
+	}
+
+	// 保存翻译结果
+	for i, translation := range result.Translations {
+		if i < len(toTranslate) {
+			// 获取原始文本和翻译后的文本
+			originalText := toTranslateOriginals[i]
+			translatedText := translation.Text
+			placeholderGen := toTranslateGenerators[i]
+
+			// 还原被保护的内容（占位符和专有名词）
+			finalTranslation, missing := restoreProtectedContent(translatedText, placeholderGen)
+
+			// 检测是否有未还原的占位符（翻译服务丢失或改写了标记）
+			if len(missing) > 0 {
+				fmt.Printf("     ❌ 错误: %d 个受保护内容未还原 | 原文: %s | 翻译: %s\n", len(missing), originalText, translatedText)
+			}
+
+			// 校验译文：标签正确嵌套，占位符、标签和专有名词与原文一致，否则该文本不使用本次译文
+			if err := validateTranslation(originalText, finalTranslation); err != nil {
+				fmt.Printf("     ❌ 错误: 译文校验失败 (%v) | 键: %s | 翻译: %s\n", err, strings.Join(toTranslateKeyPaths[i], ", "), finalTranslation)
+				if failures != nil {
+					failures[originalText] = err.Error()
+				}
+				continue
+			}
+
+			// 使用原始文本作为键保存结果
+			results[originalText] = finalTranslation
+			key := cacheKey{Provider: translator.Name(), Lang: targetLang, Protection: protectionFingerprint, Text: originalText}
+			putCachedTranslation(key, finalTranslation)
+			fileCache[key.String()] = CacheEntry{
+				Translation: finalTranslation,
+				Timestamp:   time.Now().Unix(),
+			}
+		}
+	}
+
+	recordStats(stats, 0, 0, len(toTranslate))
+	fmt.Printf("🔄 批量翻译 %d 个文本 (缓存命中: %d)\n", len(toTranslate), len(texts)-len(toTranslate))
+	return results, nil
+}
+
+// 翻译一组文本：ICU plural/select 消息按计划拆成完整句子分别翻译，再按目标语言的复数类别重新组装
+// 其他文本直接交给 translateBatch；无法翻译的文本及原因记录到 failures
+func translateMessages(translator Translator, texts *TextCollection, targetLang string, fileCache map[string]CacheEntry, stats *TranslationStats, failures map[string]string) (map[string]string, error) {
+	requests := NewTextCollection()
+	plans := make(map[string]*icuPlan)
+	for _, text := range texts.Order {
+		plan := planICUMessage(text, targetLang)
+		if plan == nil {
+			for _, path := range texts.KeyPaths[text] {
+				requests.Add(text, path)
+			}
+			continue
+		}
+		plans[text] = plan
+		for _, leaf := range plan.Leaves {
+			for _, path := range texts.KeyPaths[text] {
+				requests.Add(leaf.Fragment, fmt.Sprintf("%s [%s]", path, leaf.Label))
+			}
+		}
+	}
+
+	results, err := translateBatch(translator, requests.Order, requests.KeyPaths, targetLang, fileCache, stats, failures)
+	if err != nil {
+		return nil, err
+	}
+	if len(plans) == 0 {
+		return results, nil
+	}
+
+	// 示例数字没有原样出现在译文中（例如被写成单词）时，改用参数代替 # 重新翻译
+	retries := NewTextCollection()
+	for _, text := range texts.Order {
+		plan := plans[text]
+		if plan == nil {
+			continue
+		}
+		for _, leaf := range plan.Leaves {
+			pound := leaf.Number
+			if pound == "" {
+				pound = leaf.Pound
+			}
+			if translated, ok := results[leaf.Fragment]; ok && leaf.resolve(translated, pound) {
+				continue
+			}
+			for _, path := range texts.KeyPaths[text] {
+				retries.Add(leaf.Fallback, fmt.Sprintf("%s [%s]", path, leaf.Label))
+			}
+		}
+	}
+
+	if len(retries.Order) > 0 {
+		fallbackResults, err := translateBatch(translator, retries.Order, retries.KeyPaths, targetLang, fileCache, stats, failures)
+		if err != nil {
+			return nil, err
+		}
+		for text, translated := range fallbackResults {
+			results[text] = translated
+		}
+	}
+
+	// 组装 ICU 消息，无法还原 # 的消息保留原文
+	for _, text := range texts.Order {
+		plan := plans[text]
+		if plan == nil {
+			continue
+		}
+		reason := ""
+		for _, leaf := range plan.Leaves {
+			if leaf.Result != nil {
+				continue
+			}
+			if translated, ok := results[leaf.Fallback]; !ok || !leaf.resolve(translated, leaf.Pound) {
+				reason = "ICU 消息中的 # 无法还原"
+				if failure, ok := failures[leaf.Fallback]; ok {
+					reason = failure
+				}
+			}
+		}
+		if reason != "" {
+			fmt.Printf("     ❌ 错误: ICU 消息翻译失败 (%s) | 原文: %s\n", reason, text)
+			delete(results, text)
+			if failures != nil {
+				failures[text] = reason
+			}
+			continue
+		}
+		results[text] = formatICUMessage(plan.Nodes, false)
+	}
+
+	return results, nil
+}
+
+// 语言注册表：目录名（BCP-47 标签）到内部语言代码以及各翻译服务代码的唯一映射
+// 繁简中文、巴西/欧洲葡萄牙语、挪威语 (no/nb) 等变体分别登记，不认识的语言直接报错，不再猜测
+type LocaleInfo struct {
+	Tag     string   // 规范的 BCP-47 标签，如 zh-Hant
+	Code    string   // 内部语言代码（-lang 参数、缓存键、日志），如 ZH-HANT
+	Google  string   // Google Cloud Translation 语言代码，空表示不支持
+	DeepL   string   // DeepL 目标语言代码，空表示不支持
+	Name    string   // 英文名称（用于 LLM 提示词）
+	Aliases []string // 其他写法，如目录名 zh-TW、旧版 -lang 代码
+}
+
+var localeRegistry = []LocaleInfo{
+	{Tag: "en", Code: "EN", Google: "en", DeepL: "EN-US", Name: "English", Aliases: []string{"en-US"}},
+	{Tag: "en-GB", Code: "EN-GB", Google: "en", DeepL: "EN-GB", Name: "British English"},
+	{Tag: "zh-Hans", Code: "ZH", Google: "zh-CN", DeepL: "ZH-HANS", Name: "Simplified Chinese", Aliases: []string{"zh", "zh-CN", "zh-SG", "zh-Hans-CN"}},
+	{Tag: "zh-Hant", Code: "ZH-HANT", Google: "zh-TW", DeepL: "ZH-HANT", Name: "Traditional Chinese (Taiwan)", Aliases: []string{"zh-TW", "zh-HK", "zh-MO", "zh-Hant-TW", "zh-Hant-HK"}},
+	{Tag: "ja", Code: "JA", Google: "ja", DeepL: "JA", Name: "Japanese"},
+	{Tag: "ko", Code: "KO", Google: "ko", DeepL: "KO", Name: "Korean"},
+	{Tag: "ar", Code: "AR", Google: "ar", DeepL: "AR", Name: "Arabic"},
+	{Tag: "fr", Code: "FR", Google: "fr", DeepL: "FR", Name: "French"},
+	{Tag: "de", Code: "DE", Google: "de", DeepL: "DE", Name: "German"},
+	{Tag: "it", Code: "IT", Google: "it", DeepL: "IT", Name: "Italian"},
+	{Tag: "es", Code: "ES", Google: "es", DeepL: "ES", Name: "Spanish"},
+	{Tag: "pt-BR", Code: "PT-BR", Google: "pt", DeepL: "PT-BR", Name: "Brazilian Portuguese", Aliases: []string{"pt"}},
+	{Tag: "pt-PT", Code: "PT-PT", Google: "pt-PT", DeepL: "PT-PT", Name: "European Portuguese"},
+	{Tag: "ru", Code: "RU", Google: "ru", DeepL: "RU", Name: "Russian"},
+	{Tag: "nl", Code: "NL", Google: "nl", DeepL: "NL", Name: "Dutch"},
+	{Tag: "sv", Code: "SV", Google: "sv", DeepL: "SV", Name: "Swedish"},
+	{Tag: "da", Code: "DA", Google: "da", DeepL: "DA", Name: "Danish"},
+	{Tag: "fi", Code: "FI", Google: "fi", DeepL: "FI", Name: "Finnish"},
+	{Tag: "pl", Code: "PL", Google: "pl", DeepL: "PL", Name: "Polish"},
+	{Tag: "tr", Code: "TR", Google: "tr", DeepL: "TR", Name: "Turkish"},
+	// 挪威语：目录名沿用 no，内部代码保持 NO（缓存键不变），DeepL 只支持书面挪威语 NB
+	{Tag: "nb", Code: "NO", Google: "no", DeepL: "NB", Name: "Norwegian (Bokmål)", Aliases: []string{"no", "nb-NO", "no-NO"}},
+}
+
+// 按标签、内部代码或别名查找语言（不区分大小写，"_" 等同于 "-"）
+// 找不到时依次去掉末尾的子标签（如 zh-Hant-MO -> zh-Hant，de-AT -> de），仍找不到则报错
+func resolveLocale(tag string) (*LocaleInfo, error) {
+	normalized := strings.ToLower(strings.ReplaceAll(strings.TrimSpace(tag), "_", "-"))
+	for candidate := normalized; candidate != ""; {
+		for i := range localeRegistry {
+			info := &localeRegistry[i]
+			if strings.ToLower(info.Tag) == candidate || strings.ToLower(info.Code) == candidate {
+				return info, nil
+			}
+			for _, alias := range info.Aliases {
+				if strings.ToLower(alias) == candidate {
+					return info, nil
+				}
+			}
+		}
+		cut := strings.LastIndex(candidate, "-")
+		if cut < 0 {
+			break
+		}
+		candidate = candidate[:cut]
+	}
+
+	supported := make([]string, 0, len(localeRegistry))
+	for _, info := range localeRegistry {
+		supported = append(supported, info.Tag)
+	}
+	return nil, fmt.Errorf("不支持的语言: %q (已登记: %s)", tag, strings.Join(supported, ", "))
+}
+
+// 根据目录名（例如 "messages/zh-TW" -> "zh-TW"）查找目标语言
+func localeForDir(dirPath string) (*LocaleInfo, error) {
+	return resolveLocale(filepath.Base(filepath.Clean(dirPath)))
+}
+
+// 各翻译服务支持的内部语言代码
+func registryCodes(supported func(info LocaleInfo) bool) []string {
+	codes := []string{}
+	for _, info := range localeRegistry {
+		if supported(info) {
+			codes = append(codes, info.Code)
+		}
+	}
+	return codes
+}
+
+// 检查是否为纯占位符 - 只有占位符，没有其他文本
+func isPlaceholder(text string) bool {
+	// 如果文本完全由占位符组成，跳过翻译
+	// 例如："{name}", "{count}", "{progress}" 等
+	// 但 "Welcome back, {name}" 包含实际文本，应该被翻译
+
+	// 使用正则表达式检测纯占位符模式（只能是 {xxx}）
+	return purePlaceholderRegex.MatchString(text)
+}
+
+// 纯占位符模式（只能是 {xxx}）
+var purePlaceholderRegex = regexp.MustCompile(`^\{[a-zA-Z0-9_]+\}$`)
+
+// 保护标记：把不能翻译的内容（占位符、专有名词）包装成翻译服务不会修改的标记
+// 不同翻译服务使用各自原生的"不翻译"标记，还原时按 id 精确替换，不会误伤原文中的 # 或数字
+type ProtectionMarkup interface {
+	// 将受保护内容包装为标记（id 在单个文本内唯一）
+	Wrap(id int, content string) string
+	// 转义普通文本（HTML/XML 模式需要转义 <、>、&）
+	Escape(text string) string
+	// 还原译文：标记替换回原内容，普通文本反转义；返回译文中缺失的 id
+	Restore(translated string, protected map[int]string) (string, []int)
+}
+
+// 支持原生"不翻译"标记的翻译服务；未实现该接口的服务使用哨兵标记
+type MarkupTranslator interface {
+	Translator
+	ProtectionMarkup() ProtectionMarkup
+}
+
+// 选择文本使用的保护标记
+func markupFor(translator Translator, text string) ProtectionMarkup {
+	if mt, ok := translator.(MarkupTranslator); ok {
+		return mt.ProtectionMarkup()
+	}
+	return newSentinelMarkup(text)
+}
+
+// 按正则找到的标记还原译文：标记替换为受保护内容，标记之间的普通文本经 unescape 处理
+func restoreByPattern(translated string, pattern *regexp.Regexp, protected map[int]string, unescape func(string) string) (string, []int) {
+	var sb strings.Builder
+	restored := make(map[int]bool)
+	last := 0
+
+	for _, loc := range pattern.FindAllStringSubmatchIndex(translated, -1) {
+		id, err := strconv.Atoi(translated[loc[2]:loc[3]])
+		content, ok := protected[id]
+		if err != nil || !ok {
+			// 不认识的标记保持原样
+			continue
+		}
+		sb.WriteString(unescape(translated[last:loc[0]]))
+		sb.WriteString(content)
+		restored[id] = true
+		last = loc[1]
+	}
+	sb.WriteString(unescape(translated[last:]))
+
+	missing := []int{}
+	for id := range protected {
+		if !restored[id] {
+			missing = append(missing, id)
+		}
+	}
+	sort.Ints(missing)
+	return sb.String(), missing
+}
+
+// Google HTML 模式：<span translate="no" id="p1">内容</span>
+// 请求时需要设置 format=html，译文中的普通文本会被 HTML 转义
+type htmlMarkup struct{}
+
+var htmlMarkupPattern = regexp.MustCompile(`(?s)<span[^>]*?\bid="?p(\d+)"?[^>]*>.*?</span>`)
+
+func (htmlMarkup) Wrap(id int, content string) string {
+	return fmt.Sprintf(`<span translate="no" id="p%d">%s</span>`, id, html.EscapeString(content))
+}
+
+func (htmlMarkup) Escape(text string) string {
+	return html.EscapeString(text)
+}
+
+func (htmlMarkup) Restore(translated string, protected map[int]string) (string, []int) {
+	return restoreByPattern(translated, htmlMarkupPattern, protected, html.UnescapeString)
+}
+
+// DeepL XML 模式：<x id="1">内容</x>，请求时设置 tag_handling=xml、ignore_tags=x
+type xmlMarkup struct{}
+
+var xmlMarkupPattern = regexp.MustCompile(`(?s)<x\s+id="(\d+)"\s*(?:/>|>.*?</x>)`)
+
+func (xmlMarkup) Wrap(id int, content string) string {
+	return fmt.Sprintf(`<x id="%d">%s</x>`, id, xmlEscape(content))
+}
+
+func (xmlMarkup) Escape(text string) string {
+	return xmlEscape(text)
+}
+
+func (xmlMarkup) Restore(translated string, protected map[int]string) (string, []int) {
+	return restoreByPattern(translated, xmlMarkupPattern, protected, html.UnescapeString)
+}
+
+// XML 转义（只处理 DeepL XML 模式需要的 &、<、>）
+func xmlEscape(text string) string {
+	return strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;").Replace(text)
+}
+
+// 哨兵标记：⟦1⟧，用于不支持原生标记的服务（如 LLM）
+// 括号字符保证不出现在原文中，还原时只匹配完整的 开括号+数字+闭括号
+type sentinelMarkup struct {
+	open, close string
+	pattern     *regexp.Regexp
+}
+
+// 候选的哨兵括号（还原用的正则预先编译）
+var sentinelCandidates = []*sentinelMarkup{
+	compileSentinelMarkup("⟦", "⟧"),
+	compileSentinelMarkup("⟪", "⟫"),
+	compileSentinelMarkup("⦃", "⦄"),
+}
+
+// 创建哨兵标记并编译还原用的正则
+func compileSentinelMarkup(open, close string) *sentinelMarkup {
+	return &sentinelMarkup{
+		open:    open,
+		close:   close,
+		pattern: regexp.MustCompile(regexp.QuoteMeta(open) + `(\d+)` + regexp.QuoteMeta(close)),
+	}
+}
+
+// 为文本选择一对原文中不存在的哨兵括号
+// 候选都被占用时使用私有区字符（U+E000 起），保证不冲突
+func newSentinelMarkup(text string) *sentinelMarkup {
+	for _, candidate := range sentinelCandidates {
+		if !strings.Contains(text, candidate.open) && !strings.Contains(text, candidate.close) {
+			return candidate
+		}
+	}
+	for offset := rune(0); ; offset += 2 {
+		open, close := string(0xE000+offset), string(0xE001+offset)
+		if !strings.Contains(text, open) && !strings.Contains(text, close) {
+			return compileSentinelMarkup(open, close)
+		}
+	}
+}
+
+func (m *sentinelMarkup) Wrap(id int, content string) string {
+	return fmt.Sprintf("%s%d%s", m.open, id, m.close)
+}
+
+func (m *sentinelMarkup) Escape(text string) string {
+	return text
+}
+
+func (m *sentinelMarkup) Restore(translated string, protected map[int]string) (string, []int) {
+	return restoreByPattern(translated, m.pattern, protected, func(s string) string { return s })
+}
+
+// 单个文本的占位符生成器：分配 id 并记录受保护的内容
+type PlaceholderGenerator struct {
+	counter   int
+	markup    ProtectionMarkup
+	Protected map[int]string    // id -> 原始内容（术语为规定译法）
+	Glossary  map[string]string // 需要在客户端替换的术语：英文术语 -> 规定译法
+}
+
+// 创建新的占位符生成器
+func NewPlaceholderGenerator(markup ProtectionMarkup) *PlaceholderGenerator {
+	return &PlaceholderGenerator{markup: markup, Protected: make(map[int]string)}
+}
+
+// 为受保护内容生成标记
+func (pg *PlaceholderGenerator) Generate(content string) string {
+	pg.counter++
+	pg.Protected[pg.counter] = content
+	return pg.markup.Wrap(pg.counter, content)
+}
+
+// 原文中需要保护的一段内容（字节区间），replacement 非空时还原为该内容（术语的规定译法）
+type protectedSpan struct {
+	start, end  int
+	replacement string
+}
+
+// 检查区间是否与已有区间重叠
+func overlapsAny(spans []protectedSpan, start, end int) bool {
+	for _, span := range spans {
+		if start < span.end && end > span.start {
+			return true
+		}
+	}
+	return false
+}
+
+// 是否为单词字符（任意文字的字母、数字和下划线）
+// 汉字和假名之间本来就没有空格，不作为单词字符，因此 "使用API" 中的 API 仍能匹配
+func isWordRune(r rune) bool {
+	if unicode.In(r, unicode.Han, unicode.Hiragana, unicode.Katakana) {
+		return false
+	}
+	return r == '_' || unicode.IsLetter(r) || unicode.IsDigit(r) || unicode.Is(unicode.Mn, r)
+}
+
+// 检查 [start, end) 是否为完整的词：首尾是单词字符时，外侧不能紧接单词字符
+// 以符号开头或结尾的条目（如 "<"、"C#"）在该侧不要求边界
+func isWholeWord(text string, start, end int) bool {
+	first, _ := utf8.DecodeRuneInString(text[start:end])
+	last, _ := utf8.DecodeLastRuneInString(text[start:end])
+	if start > 0 && isWordRune(first) {
+		if r, _ := utf8.DecodeLastRuneInString(text[:start]); isWordRune(r) {
+			return false
+		}
+	}
+	if end < len(text) && isWordRune(last) {
+		if r, _ := utf8.DecodeRuneInString(text[end:]); isWordRune(r) {
+			return false
+		}
+	}
+	return true
+}
+
+// 按长度优先加入候选区间，跳过与已有区间重叠的候选
+func addLongestFirst(spans []protectedSpan, candidates []protectedSpan) []protectedSpan {
+	sort.SliceStable(candidates, func(i, j int) bool {
+		if li, lj := candidates[i].end-candidates[i].start, candidates[j].end-candidates[j].start; li != lj {
+			return li > lj
+		}
+		return candidates[i].start < candidates[j].start
+	})
+	for _, candidate := range candidates {
+		if !overlapsAny(spans, candidate.start, candidate.end) {
+			spans = append(spans, candidate)
+		}
+	}
+	return spans
+}
+
+// Aho-Corasick 自动机：一次扫描即可找出所有词条的出现位置，耗时只与文本长度和匹配数量有关
+// 按 rune 匹配并统一转为小写，区分大小写的条目由调用方再与原文比对
+type acMatcher struct {
+	nodes   []acNode
+	lengths []int // 每个词条的 rune 数
+}
+
+// 自动机节点
+type acNode struct {
+	next    map[rune]int
+	fail    int
+	outputs []int // 在该节点结束的词条（包括沿失败链可达的词条）
+}
+
+// 一次匹配：[Start, End) 为字节区间，Pattern 为词条序号
+type acMatch struct {
+	Start, End int
+	Pattern    int
+}
+
+// 构建自动机（加载配置时调用一次）
+func newACMatcher(patterns []string) *acMatcher {
+	m := &acMatcher{nodes: []acNode{{next: make(map[rune]int)}}, lengths: make([]int, len(patterns))}
+
+	// 构建字典树
+	for id, pattern := range patterns {
+		if pattern == "" {
+			continue
+		}
+		state := 0
+		for _, r := range pattern {
+			r = unicode.ToLower(r)
+			next, ok := m.nodes[state].next[r]
+			if !ok {
+				next = len(m.nodes)
+				m.nodes = append(m.nodes, acNode{next: make(map[rune]int)})
+				m.nodes[state].next[r] = next
+			}
+			state = next
+			m.lengths[id]++
+		}
+		m.nodes[state].outputs = append(m.nodes[state].outputs, id)
+	}
+
+	// 广度优先构建失败指针，并合并失败链上的输出
+	queue := []int{}
+	for _, child := range m.nodes[0].next {
+		queue = append(queue, child)
+	}
+	for len(queue) > 0 {
+		state := queue[0]
+		queue = queue[1:]
+		for r, child := range m.nodes[state].next {
+			fail := m.nodes[state].fail
+			for fail > 0 {
+				if _, ok := m.nodes[fail].next[r]; ok {
+					break
+				}
+				fail = m.nodes[fail].fail
+			}
+			if next, ok := m.nodes[fail].next[r]; ok && next != child {
+				m.nodes[child].fail = next
+			}
+			m.nodes[child].outputs = append(m.nodes[child].outputs, m.nodes[m.nodes[child].fail].outputs...)
+			queue = append(queue, child)
+		}
+	}
+	return m
+}
+
+// 查找所有词条的出现位置（可能互相重叠）
+func (m *acMatcher) FindAll(text string) []acMatch {
+	matches := []acMatch{}
+	if len(m.nodes) == 1 {
+		return matches
+	}
+
+	starts := []int{} // 已扫描的每个 rune 的起始字节位置
+	state := 0
+	for i := 0; i < len(text); {
+		r, size := utf8.DecodeRuneInString(text[i:])
+		starts = append(starts, i)
+		r = unicode.ToLower(r)
+
+		for state > 0 {
+			if _, ok := m.nodes[state].next[r]; ok {
+				break
+			}
+			state = m.nodes[state].fail
+		}
+		state = m.nodes[state].next[r] // 根节点没有该字符时为 0（回到根）
+		i += size
+
+		for _, id := range m.nodes[state].outputs {
+			matches = append(matches, acMatch{Start: starts[len(starts)-m.lengths[id]], End: i, Pattern: id})
+		}
+	}
+	return matches
+}
+
+// 专有名词匹配器：字面条目使用 Aho-Corasick 自动机，正则条目使用预编译的正则
+type properNounMatcher struct {
+	literals  []ProperNoun // 自动机中第 i 个词条对应的条目
+	automaton *acMatcher
+	regexes   []ProperNoun
+}
+
+// 构建专有名词匹配器
+func newProperNounMatcher(nouns []ProperNoun) *properNounMatcher {
+	m := &properNounMatcher{}
+	terms := []string{}
+	for _, noun := range nouns {
+		if noun.Match == matchRegex {
+			m.regexes = append(m.regexes, noun)
+			continue
+		}
+		m.literals = append(m.literals, noun)
+		terms = append(terms, noun.Term)
+	}
+	m.automaton = newACMatcher(terms)
+	return m
+}
+
+// 查找文本中所有专有名词的出现位置（已检查大小写和词边界，可能互相重叠）
+func (m *properNounMatcher) FindAll(text string) []protectedSpan {
+	spans := []protectedSpan{}
+	for _, match := range m.automaton.FindAll(text) {
+		noun := m.literals[match.Pattern]
+		if noun.Match != matchCaseInsensitive && text[match.Start:match.End] != noun.Term {
+			continue
+		}
+		if isWholeWord(text, match.Start, match.End) {
+			spans = append(spans, protectedSpan{start: match.Start, end: match.End})
+		}
+	}
+	for _, noun := range m.regexes {
+		for _, loc := range noun.pattern.FindAllStringIndex(text, -1) {
+			if loc[0] < loc[1] {
+				spans = append(spans, protectedSpan{start: loc[0], end: loc[1]})
+			}
+		}
+	}
+	return spans
+}
+
+// 匹配 next-intl 富文本标签（t.rich），如 <b>、</link>、<br/>
+var richTagRegex = regexp.MustCompile(`<(/?)([a-zA-Z][a-zA-Z0-9_-]*)\s*(/?)>`)
+
+// 匹配原始占位符（如 {name}、{count}，以及 {amount, number} 这类带格式的 ICU 参数）
+var originalPlaceholderRegex = regexp.MustCompile(`\{\s*[a-zA-Z_][a-zA-Z0-9_]*\s*(?:,[^{}]*)?\}`)
+
+// 客户端保护：将富文本标签、占位符和专有名词替换为特殊标记，这样翻译服务不会翻译它们
+// 先在原文上确定所有受保护区间，再拼接：普通文本经 Escape，受保护内容经 Wrap
+func protectAllContentWithGenerator(text string, placeholderGen *PlaceholderGenerator) (string, map[int]string) {
+	spans := []protectedSpan{}
+
+	// 第一步：保护富文本标签（开始和结束标签分别保护，标签之间的文本照常翻译）
+	for _, loc := range richTagRegex.FindAllStringIndex(text, -1) {
+		spans = append(spans, protectedSpan{start: loc[0], end: loc[1]})
+	}
+
+	// 第二步：保护占位符（如 {name}, {count} 等）
+	for _, loc := range originalPlaceholderRegex.FindAllStringIndex(text, -1) {
+		if !overlapsAny(spans, loc[0], loc[1]) {
+			spans = append(spans, protectedSpan{start: loc[0], end: loc[1]})
+		}
+	}
+
+	// 第三步：术语替换为规定译法（整词、忽略大小写，长的优先）
+	if len(placeholderGen.Glossary) > 0 {
+		terms := []protectedSpan{}
+		for _, match := range glossaryMatcher.FindAll(text) {
+			target, ok := placeholderGen.Glossary[glossarySources[match.Pattern]]
+			if ok && isWholeWord(text, match.Start, match.End) {
+				terms = append(terms, protectedSpan{start: match.Start, end: match.End, replacement: matchTermCase(text[match.Start:match.End], target)})
+			}
+		}
+		spans = addLongestFirst(spans, terms)
+	}
+
+	// 第四步：保护专有名词（长的优先，避免部分替换）
+	spans = addLongestFirst(spans, properNounIndex.FindAll(text))
+
+	// 第五步：按位置拼接结果
+	sort.Slice(spans, func(i, j int) bool { return spans[i].start < spans[j].start })
+	var sb strings.Builder
+	last := 0
+	for _, span := range spans {
+		sb.WriteString(placeholderGen.markup.Escape(text[last:span.start]))
+		if span.replacement != "" {
+			sb.WriteString(placeholderGen.Generate(span.replacement))
+		} else {
+			sb.WriteString(placeholderGen.Generate(text[span.start:span.end]))
+		}
+		last = span.end
+	}
+	sb.WriteString(placeholderGen.markup.Escape(text[last:]))
+
+	return sb.String(), placeholderGen.Protected
+}
+
+// 还原被保护的内容：按标记 id 精确替换，返回译文中缺失的 id
+func restoreProtectedContent(text string, placeholderGen *PlaceholderGenerator) (string, []int) {
+	return placeholderGen.markup.Restore(text, placeholderGen.Protected)
+}
+
+// 检查译文中的富文本标签：与原文的标签完全一致，并且正确嵌套
+// 翻译服务可以调整标签位置，但不能丢失、重复或交叉嵌套（next-intl 渲染时会报错）
+func validateRichTags(source, translated string) error {
+	sourceTags := richTagRegex.FindAllString(source, -1)
+	translatedTags := richTagRegex.FindAllStringSubmatch(translated, -1)
+	if len(sourceTags) == 0 && len(translatedTags) == 0 {
+		return nil
+	}
+
+	// 检查嵌套
+	stack := []string{}
+	counts := make(map[string]int)
+	for _, match := range translatedTags {
+		counts[match[0]]++
+		closing, name, selfClosing := match[1] == "/", match[2], match[3] == "/"
+		switch {
+		case selfClosing:
+		case closing:
+			if len(stack) == 0 {
+				return fmt.Errorf("</%s> 没有对应的开始标签", name)
+			}
+			if open := stack[len(stack)-1]; open != name {
+				return fmt.Errorf("<%s> 与 </%s> 交叉嵌套", open, name)
+			}
+			stack = stack[:len(stack)-1]
+		default:
+			stack = append(stack, name)
+		}
+	}
+	if len(stack) > 0 {
+		return fmt.Errorf("<%s> 未闭合", stack[len(stack)-1])
+	}
+
+	// 检查标签与原文一致
+	for _, tag := range sourceTags {
+		counts[tag]--
+	}
+	tags := make([]string, 0, len(counts))
+	for tag := range counts {
+		tags = append(tags, tag)
+	}
+	sort.Strings(tags)
+	for _, tag := range tags {
+		count := counts[tag]
+		if count > 0 {
+			return fmt.Errorf("多出标签 %s", tag)
+		}
+		if count < 0 {
+			return fmt.Errorf("缺少标签 %s", tag)
+		}
+	}
+	return nil
+}
+
+// 校验用的记号：ICU 参数（{name}、{amount, number}）和 ${amount} 形式的模板变量
+var parityTokenRegex = regexp.MustCompile(`\$\{[^{}]*\}|\{\s*[a-zA-Z_][a-zA-Z0-9_]*\s*(?:,[^{}]*)?\}`)
+
+// 校验译文：富文本标签正确嵌套，占位符、模板变量、标签和专有名词的数量与原文一致
+func validateTranslation(source, translated string) error {
+	if err := validateRichTags(source, translated); err != nil {
+		return err
+	}
+
+	want := make(map[string]int)
+	got := make(map[string]int)
+	for _, pattern := range []*regexp.Regexp{parityTokenRegex, richTagRegex} {
+		for _, token := range pattern.FindAllString(source, -1) {
+			want[token]++
+		}
+		for _, token := range pattern.FindAllString(translated, -1) {
+			got[token]++
+		}
+	}
+
+	// 专有名词：按原文中出现的名词统计译文中的次数
+	// 译文中名词可能紧接其他文字（如韩语助词 "FluxReve의"），因此不检查词边界
+	nouns := []string{}
+	for _, span := range addLongestFirst(nil, properNounIndex.FindAll(source)) {
+		noun := source[span.start:span.end]
+		if want[noun] == 0 {
+			nouns = append(nouns, noun)
+		}
+		want[noun]++
+	}
+	sort.SliceStable(nouns, func(i, j int) bool { return len(nouns[i]) > len(nouns[j]) })
+	spans := []protectedSpan{}
+	for _, noun := range nouns {
+		for offset := 0; ; {
+			index := strings.Index(translated[offset:], noun)
+			if index < 0 {
+				break
+			}
+			start := offset + index
+			end := start + len(noun)
+			if !overlapsAny(spans, start, end) {
+				spans = append(spans, protectedSpan{start: start, end: end})
+				got[noun]++
+			}
+			offset = end
+		}
+	}
+
+	// 汇总所有不一致的记号
+	tokens := make([]string, 0, len(want)+len(got))
+	for token := range want {
+		tokens = append(tokens, token)
+	}
+	for token := range got {
+		if _, ok := want[token]; !ok {
+			tokens = append(tokens, token)
+		}
+	}
+	sort.Strings(tokens)
+
+	problems := []string{}
+	for _, token := range tokens {
+		switch {
+		case got[token] == 0:
+			problems = append(problems, "缺少 "+token)
+		case want[token] == 0:
+			problems = append(problems, "多出 "+token)
+		case got[token] != want[token]:
+			problems = append(problems, fmt.Sprintf("%s 数量不一致 (原文 %d, 译文 %d)", token, want[token], got[token]))
+		}
+	}
+	if len(problems) > 0 {
+		return fmt.Errorf("%s", strings.Join(problems, "; "))
+	}
+	return nil
+}
+
+// 未翻译检测
+// 翻译服务有时原样返回英文或返回错误的文字（如繁体语言中出现简体字），这里离线检测这些译文
+
+// 提取需要检测的文字：只保留 ICU 字面文本，去掉参数、标签和专有名词
+func detectableText(text string) string {
+	literal := text
+	if nodes, err := parseICUMessage(text); err == nil {
+		var sb strings.Builder
+		collectICULiterals(nodes, &sb)
+		literal = sb.String()
+	} else {
+		literal = parityTokenRegex.ReplaceAllString(literal, " ")
+	}
+	literal = richTagRegex.ReplaceAllString(literal, " ")
+
+	spans := addLongestFirst(nil, properNounIndex.FindAll(literal))
+	sort.Slice(spans, func(i, j int) bool { return spans[i].start < spans[j].start })
+	var sb strings.Builder
+	last := 0
+	for _, span := range spans {
+		sb.WriteString(literal[last:span.start])
+		sb.WriteString(" ")
+		last = span.end
+	}
+	sb.WriteString(literal[last:])
+	return sb.String()
+}
+
+// 收集语法树中的字面文本（各选项之间用空格分隔）
+func collectICULiterals(nodes []icuNode, sb *strings.Builder) {
+	for _, node := range nodes {
+		switch n := node.(type) {
+		case *icuText:
+			sb.WriteString(n.Value)
+		case *icuSelector:
+			for _, option := range n.Options {
+				sb.WriteString(" ")
+				collectICULiterals(option.Message, sb)
+			}
+		default:
+			sb.WriteString(" ")
+		}
+	}
+}
+
+// 拆分为小写单词（只保留字母）
+func letterWords(text string) []string {
+	return strings.FieldsFunc(strings.ToLower(text), func(r rune) bool { return !unicode.IsLetter(r) })
+}
+
+// 文字系统
+var scriptTables = []struct {
+	Name  string
+	Table *unicode.RangeTable
+}{
+	{"Latin", unicode.Latin},
+	{"Han", unicode.Han},
+	{"Hiragana", unicode.Hiragana},
+	{"Katakana", unicode.Katakana},
+	{"Hangul", unicode.Hangul},
+	{"Arabic", unicode.Arabic},
+	{"Cyrillic", unicode.Cyrillic},
+}
+
+// 各语言允许的文字系统（未列出的语言使用拉丁字母）
+var languageScripts = map[string][]string{
+	"zh": {"Han"},
+	"ja": {"Han", "Hiragana", "Katakana"},
+	"ko": {"Hangul", "Han"},
+	"ar": {"Arabic"},
+	"ru": {"Cyrillic"},
+	"uk": {"Cyrillic"},
+}
+
+// 统计每种文字系统的字母数量
+func countScripts(text string) (map[string]int, int) {
+	counts := make(map[string]int)
+	total := 0
+	for _, r := range text {
+		if !unicode.IsLetter(r) {
+			continue
+		}
+		total++
+		for _, script := range scriptTables {
+			if unicode.Is(script.Table, r) {
+				counts[script.Name]++
+				break
+			}
+		}
+	}
+	return counts, total
+}
+
+// 简体字和对应的繁体字（只收录两者字形不同的常用字）
+const simplifiedTraditionalPairs = "们們这這个個为為说說时時会會来來对對没沒么麼过過还還应應与與发發请請让讓设設账賬户戶选選择擇图圖创創订訂阅閱费費积積价價开開关關页頁" +
+	"视視频頻载載员員换換删刪试試语語种種单單击擊输輸确確认認号號码碼复複实實现現级級专專业業优優质質务務网網络絡" +
+	"录錄册冊问問题題帮幫联聯记記历歷显顯风風计計额額条條细細节節动動态態场場边邊缘緣"
+
+// 简体到繁体、繁体到简体的字表
+var simplifiedToTraditional, traditionalToSimplified = buildChinesePairs(simplifiedTraditionalPairs)
+
+// 解析字对，跳过两者相同的字
+func buildChinesePairs(pairs string) (map[rune]rune, map[rune]rune) {
+	s2t := make(map[rune]rune)
+	t2s := make(map[rune]rune)
+	runes := []rune(pairs)
+	for i := 0; i+1 < len(runes); i += 2 {
+		if runes[i] != runes[i+1] {
+			s2t[runes[i]] = runes[i+1]
+			t2s[runes[i+1]] = runes[i]
+		}
+	}
+	return s2t, t2s
+}
+
+// 是否为繁体中文（zh-Hant 及其别名 zh-TW、zh-HK 等）
+func isTraditionalChinese(lang string) bool {
+	info, err := resolveLocale(lang)
+	return err == nil && info.Tag == "zh-Hant"
+}
+
+// 字符三元组语言画像（按频率排序，由 messages 目录中的现有译文统计，_ 表示词边界）
+var trigramProfileSources = map[string]string{
+	"en": "ion age tio _im ima mag ing _pr ng_ on_ es_ ge_ ati _an and nd_ pro ed_ rat _co or_ _re al_ era for _ge gen ner ate _to ene to_ you _yo rea _th our ts_ ur_ ent cre _se le_ _cr _in _ai ai_ _fo ess ter con eat the nt_ er_ _de res se_ nal ect ges ons ly_ ssi ty_ ona ve_ _wi _mo ns_ ali ual ity te_ he_ tin _us wit ith _qu ive nce rma _su lit sio com _st _te _ma ers th_ nte rs_ red _ba in_ re_ ont eed",
+	"de": "en_ er_ ung ie_ der _un gen ich und _bi nd_ ng_ ell sch bil ild _si che ten sie _er _pr ste ver lle _di die _ge ion _de _zu den ein pro nde _ve ers te_ ier _au _be ene eit nen ch_ ere lde ter le_ it_ hre _ei ren lic ent ner one nte hen run sse tio ert _in _ih for tel zu_ on_ ige auf ser es_ len ern erz nge _fü rst end ür_ _da ati für _ko ihr nel ge_ re_ eru _vo cht ode _an _mi ne_ ist mit eri ngs",
+	"fr": "es_ _de ion de_ tio on_ age ent _co _im ima mag ati nt_ les _pr _le le_ des et_ _d_ er_ _et men ur_ ge_ ns_ pro re_ con onn _qu _vo que ges ez_ _la _po la_ _gé our _pa gén éné te_ _à_ rat ts_ nér ons tre _un _l_ nne ne_ pou _en par ess ité _cr lle uti cré us_ ili té_ eme ali nte ell ant ran éra com en_ ce_ nce ssi lit _ré eur sio lis ue_ _se res ous _ia ia_ _su une tra til _in _no ren nel gra _mo",
+	"it": "ion _di di_ zio ne_ _im _co re_ le_ mag to_ _pr ni_ one ent ti_ agi imm gin mma azi te_ _de _in ali pro _e_ nte con per gen la_ ini li_ ell era _ri _pe ene _ge men ner er_ are ta_ del ess ati ona _cr _la tà_ _se rea no_ ssi cre _al ità ili ale ato raz rat ual _qu lit ett oni ai_ ten nal _ai str za_ _da _le ine tra na_ ro_ enz ost com sio ia_ _mo est nza on_ gra ame izz zza _il ri_ ra_ qua ris gli",
+	"es": "_de de_ es_ os_ gen ón_ ión ene _co _im ció _pr as_ aci nes ent con _la pro ion en_ nte ra_ _y_ ida _se do_ la_ cio ar_ _pa era par ner _ge _re mág res imá áge ara les te_ al_ ado _el ima dad el_ rea mag ona age est to_ ia_ _in _cr ad_ _es ta_ _en or_ ali ien _ca pre rac tos _lo esi one tra da_ nal _su dos _a_ cre on_ ici ale lid na_ _qu los cia rec _po ica que nci com men por ue_ iza ten ada _ma",
+	"sv": "er_ ing för bil ild era _bi _fö ner _oc ch_ och ng_ gen _pr ell ar_ der ra_ nin ion ör_ en_ ter ll_ tt_ pro _in ene rer rin att et_ _de eri ati de_ nde ere ler _me ill lig _ai ai_ til _ko med one _di la_ _ti upp and tio _ge _an _at ska ite lle na_ _kr _up ngs nst ed_ lla lde _av _be rat änd vän om_ tiv _vi rad ga_ an_ nel ta_ _st ver din tet _va tor ali nvä kon anv _sk ess ld_ ten ade nte ad_ ssi",
+	"no": "er_ for ing lde ild bil ere _fo _bi ene ner ter gen en_ _og og_ ell rer et_ _pr or_ ng_ til sjo jon de_ lle rin re_ _de der pro _ti ler lig _in eri ne_ ge_ _me _ai ai_ one il_ opp te_ _be ed_ le_ ver _op nge tte _av ig_ _di _ge ser ste nde _ko kre _kr ke_ rt_ med tet inn _å_ ert eks ruk nin _st ali ngs mer om_ av_ det ten bru erk ige ite end se_ _se _br nel ger ll_ din _re den tiv sti deg ker jen",
+	"da": "er_ lle ere ill ing led til bil der et_ _bi ede de_ for gen _pr og_ ner _ti _og rer _fo ter _de ene il_ ng_ ion nde pro en_ lig ge_ ell ed_ re_ rin eri _di ger at_ _ai ai_ ler _op _in _me _ge or_ one els nge _at le_ ind se_ lse _be ati ige and tte nin tio _kr ret med ig_ ver tet _ko ive red hed end _af ne_ sti eks _se din ali den ite nel kre age bru rug es_ und _br sni af_ ste res ess lit det sio",
+	"fi": "en_ kuv _ku ja_ ta_ ist sta _ja luo _te uva _lu _ta in_ ise ksi si_ tek an_ ia_ aa_ ais itt lla la_ nen ine lis all lli _va äyt ima att isi aat äly taa tai _kä sti ien tä_ käy ttä ytt uvi eko tta _tu at_ on_ koä oäl mis tel mat kse ill _ti suu uks tti ti_ tte _ko ell tar esi le_ _si oit sit ita lle sen _su sa_ sia amm til min _jo ssa _se nti ste mma tam ast ust ai_ et_ nni nta int _mu see ois _pr",
+}
+
+// 解析后的语言画像：三元组到排名
+var trigramProfiles = buildTrigramProfiles(trigramProfileSources)
+
+func buildTrigramProfiles(sources map[string]string) map[string]map[string]int {
+	profiles := make(map[string]map[string]int, len(sources))
+	for lang, source := range sources {
+		ranks := make(map[string]int)
+		for i, trigram := range strings.Fields(source) {
+			ranks[strings.ReplaceAll(trigram, "_", " ")] = i
+		}
+		profiles[lang] = ranks
+	}
+	return profiles
+}
+
+// 按频率排序的文本三元组
+func textTrigrams(words []string) []string {
+	counts := make(map[string]int)
+	for _, word := range words {
+		padded := []rune(" " + word + " ")
+		for i := 0; i+3 <= len(padded); i++ {
+			counts[string(padded[i:i+3])]++
+		}
+	}
+	trigrams := make([]string, 0, len(counts))
+	for trigram := range counts {
+		trigrams = append(trigrams, trigram)
+	}
+	sort.Slice(trigrams, func(i, j int) bool {
+		if counts[trigrams[i]] != counts[trigrams[j]] {
+			return counts[trigrams[i]] > counts[trigrams[j]]
+		}
+		return trigrams[i] < trigrams[j]
+	})
+	return trigrams
+}
+
+// 文本与语言画像的排名距离（Cavnar-Trenkle），越小越接近
+func trigramDistance(trigrams []string, profile map[string]int) int {
+	distance := 0
+	for rank, trigram := range trigrams {
+		profileRank, ok := profile[trigram]
+		switch {
+		case !ok:
+			distance += len(profile)
+		case profileRank > rank:
+			distance += profileRank - rank
+		default:
+			distance += rank - profileRank
+		}
+	}
+	return distance
+}
+
+// 判断拉丁字母文本是否更像英文而不是目标语言
+// 文本太短时不判断；译文中常夹杂未列入专有名词的英文产品名，因此英文距离需要明显更小
+func looksEnglish(words []string, language string) bool {
+	profile, ok := trigramProfiles[language]
+	if !ok {
+		return false
+	}
+	letters := 0
+	for _, word := range words {
+		letters += utf8.RuneCountInString(word)
+	}
+	if letters < 30 {
+		return false
+	}
+
+	trigrams := textTrigrams(words)
+	return trigramDistance(trigrams, trigramProfiles["en"])*10 < trigramDistance(trigrams, profile)*8
+}
+
+// 检测疑似未翻译的译文，返回原因；正常时返回空
+func detectUntranslated(source, translated, lang string) string {
+	language := pluralLanguage(lang)
+	if language == "en" {
+		return ""
+	}
+	sourceWords := letterWords(detectableText(source))
+	translatedText := detectableText(translated)
+	translatedWords := letterWords(translatedText)
+
+	// 与英文原文相同：短标签在拉丁字母语言中经常相同（如 Ultra HD），至少三个单词才判断
+	if len(sourceWords) >= 3 && strings.Join(strings.Fields(detectableText(source)), " ") == strings.Join(strings.Fields(translatedText), " ") {
+		return "译文与英文原文相同"
+	}
+
+	// 文字系统：非拉丁字母语言的译文中至少要有该语言的文字（允许夹杂英文产品名，忽略 50K+ 这类极短文本）
+	counts, total := countScripts(translatedText)
+	if scripts, ok := languageScripts[language]; ok && total >= 3 {
+		expected := 0
+		for _, script := range scripts {
+			expected += counts[script]
+		}
+		if expected == 0 {
+			return fmt.Sprintf("译文中没有%s文字", strings.Join(scripts, "/"))
+		}
+	}
+
+	// 简繁混用
+	if reason := detectMixedChinese(translatedText, lang); reason != "" {
+		return reason
+	}
+
+	// 拉丁字母语言：用三元组画像识别是否仍是英文
+	if counts["Latin"]*2 >= total && looksEnglish(translatedWords, language) {
+		return "译文看起来是英文"
+	}
+	return ""
+}
+
+// 检查中文译文中是否混入另一种字形（繁体译文中的简体字，或简体译文中的繁体字）
+func detectMixedChinese(text, lang string) string {
+	if pluralLanguage(lang) != "zh" {
+		return ""
+	}
+	traditional := isTraditionalChinese(lang)
+	wrong := []string{}
+	seen := make(map[rune]bool)
+	for _, r := range text {
+		_, simplified := simplifiedToTraditional[r]
+		_, isTraditional := traditionalToSimplified[r]
+		if ((traditional && simplified) || (!traditional && isTraditional)) && !seen[r] {
+			seen[r] = true
+			wrong = append(wrong, string(r))
+		}
+	}
+	if len(wrong) == 0 {
+		return ""
+	}
+	kind := "简体字"
+	if !traditional {
+		kind = "繁体字"
+	}
+	return fmt.Sprintf("译文中出现%s: %s", kind, strings.Join(wrong, ""))
+}
+
+// 检测一组译文，返回疑似未翻译的原文及原因
+func findUntranslated(texts *TextCollection, translations map[string]string, lang string) map[string]string {
+	suspects := make(map[string]string)
+	for _, text := range texts.Order {
+		if translated, ok := translations[text]; ok {
+			if reason := detectUntranslated(text, translated, lang); reason != "" {
+				suspects[text] = reason
+			}
+		}
+	}
+	return suspects
+}
+
+// 简繁转换的结果中残留另一种字形的文本（源文本是中文，不做与英文相关的检查）
+func findUnconverted(texts *TextCollection, translations map[string]string, lang string) map[string]string {
+	suspects := make(map[string]string)
+	for _, text := range texts.Order {
+		if translated, ok := translations[text]; ok {
+			if reason := detectMixedChinese(detectableText(translated), lang); reason != "" {
+				suspects[text] = reason
+			}
+		}
+	}
+	return suspects
+}
+
+// 跳过缓存重新翻译疑似未翻译的文本，新译文没有问题时替换原有译文
+// 重新翻译的结果校验失败时保留第一次的译文
+func retryUntranslatedTexts(translator Translator, texts *TextCollection, suspects map[string]string, translations map[string]string, targetLang string, fileCache map[string]CacheEntry, stats *TranslationStats) (map[string]string, error) {
+	retries := NewTextCollection()
+	for _, text := range texts.Order {
+		if _, ok := suspects[text]; !ok {
+			continue
+		}
+		for _, path := range texts.KeyPaths[text] {
+			retries.Add(text, path)
+		}
+
+		// ICU 消息按片段缓存，需要删除每个片段的缓存
+		sources := []string{text}
+		if plan := planICUMessage(text, targetLang); plan != nil {
+			sources = sources[:0]
+			for _, leaf := range plan.Leaves {
+				sources = append(sources, leaf.Fragment, leaf.Fallback)
+			}
+		}
+		for _, source := range sources {
+			forgetCachedTranslation(cacheKey{Provider: translator.Name(), Lang: targetLang, Protection: protectionFingerprint, Text: source}, fileCache)
+		}
+	}
+
+	fmt.Printf("🔁 重新翻译 %d 个疑似未翻译的文本\n", len(retries.Order))
+	retried, err := translateMessages(translator, retries, targetLang, fileCache, stats, make(map[string]string))
+	if err != nil {
+		return nil, err
+	}
+	for text, translated := range retried {
+		translations[text] = translated
+	}
+	return findUntranslated(retries, translations, targetLang), nil
+}
+
+// 缩短超出长度限制的译文（只处理非 ICU 消息），返回仍然超出限制的原文
+// 缩短后的译文同样要通过占位符校验，并写回缓存，下次运行不再重复请求
+func shortenTranslations(translator ShorteningTranslator, texts *TextCollection, violations map[string]lengthViolation, translations map[string]string, targetLang, namespace string, fileCache map[string]CacheEntry, stats *TranslationStats) (map[string]lengthViolation, error) {
+	candidates := NewTextCollection()
+	protected := []string{}
+	generators := []*PlaceholderGenerator{}
+	limits := []string{}
+	keyPaths := [][]string{}
+	for _, text := range texts.Order {
+		violation, ok := violations[text]
+		if !ok || planICUMessage(text, targetLang) != nil {
+			continue
+		}
+		for _, path := range texts.KeyPaths[text] {
+			candidates.Add(text, path)
+		}
+		generator := NewPlaceholderGenerator(markupFor(translator, translations[text]))
+		protectedText, _ := protectAllContentWithGenerator(translations[text], generator)
+		protected = append(protected, protectedText)
+		generators = append(generators, generator)
+		limits = append(limits, violation.Rule.describe())
+		keyPaths = append(keyPaths, texts.KeyPaths[text])
+	}
+	if len(candidates.Order) == 0 {
+		return violations, nil
+	}
+	fmt.Printf("✂️  缩短 %d 个超出长度限制的译文\n", len(candidates.Order))
+
+	maxBatchSize := translator.MaxBatchSize()
+	for batchStart := 0; batchStart < len(protected); batchStart += maxBatchSize {
+		batchEnd := batchStart + maxBatchSize
+		if batchEnd > len(protected) {
+			batchEnd = len(protected)
+		}
+
+		var shortened []string
+		err := withRetry(retryPolicy, "缩短批次", func() error {
+			rateLimiter.Wait()
+			recordStats(stats, 1, 0, 0)
+			var err error
+			shortened, err = translator.ShortenBatch(protected[batchStart:batchEnd], limits[batchStart:batchEnd], keyPaths[batchStart:batchEnd], targetLang)
+			return err
+		})
+		if err != nil {
+			return nil, fmt.Errorf("缩短批次失败: %v", err)
+		}
+
+		for i, result := range shortened {
+			text := candidates.Order[batchStart+i]
+			restored, missing := restoreProtectedContent(result, generators[batchStart+i])
+			if len(missing) > 0 {
+				continue
+			}
+			if err := validateTranslation(text, restored); err != nil {
+				fmt.Printf("     ⚠️  缩短后的译文校验失败 (%v)，保留原译文 | 键: %s\n", err, strings.Join(texts.KeyPaths[text], ", "))
+				continue
+			}
+			translations[text] = restored
+			key := cacheKey{Provider: translator.Name(), Lang: targetLang, Protection: protectionFingerprint, Text: text}
+			putCachedTranslation(key, restored)
+			fileCache[key.String()] = CacheEntry{Translation: restored, Timestamp: time.Now().Unix()}
+		}
+	}
+
+	remaining := findOverLength(texts, translations, namespace)
+	return remaining, nil
+}
+
+// ICU MessageFormat 支持
+// next-intl 使用 ICU 语法，例如 "{count, plural, one {# image} other {# images}}"
+// 整条消息交给翻译服务会破坏语法，因此先解析为语法树，只翻译字面文本，再按目标语言的复数类别重新组装
+
+// ICU 语法树节点：*icuText、*icuArgument、*icuPound、*icuSelector，翻译计划中还有 *icuLeaf
+type icuNode interface{}
+
+// 字面文本（已解除 ICU 撇号转义）
+type icuText struct {
+	Value string
+}
+
+// 简单参数，如 {name}、{amount, number}，原样保留
+type icuArgument struct {
+	Name string
+	Raw  string
+}
+
+// 复数选项中的 #（代表当前数值）
+type icuPound struct{}
+
+// plural、selectordinal 或 select 参数
+type icuSelector struct {
+	Name    string
+	Kind    string
+	Offset  string
+	Options []icuOption
+}
+
+// 选择器的一个选项，如 one {# image}
+type icuOption struct {
+	Selector string
+	Message  []icuNode
+}
+
+// 按选项名查找选项
+func (s *icuSelector) option(selector string) *icuOption {
+	for i := range s.Options {
+		if s.Options[i].Selector == selector {
+			return &s.Options[i]
+		}
+	}
+	return nil
+}
+
+// ICU 消息解析器（按 rune 读取）
+type icuParser struct {
+	src []rune
+	pos int
+}
+
+// 解析 ICU 消息为语法树
+func parseICUMessage(text string) ([]icuNode, error) {
+	p := &icuParser{src: []rune(text)}
+	return p.parseMessage(false, false)
+}
+
+// 判断是否为 ICU 语法字符（# 只在复数选项中有特殊含义）
+func isICUSyntaxChar(r rune, inPlural bool) bool {
+	return r == '{' || r == '}' || (inPlural && r == '#')
+}
+
+// 解析一段消息；nested 为 true 时遇到 } 结束（由调用方消费）
+func (p *icuParser) parseMessage(inPlural, nested bool) ([]icuNode, error) {
+	nodes := []icuNode{}
+	var text strings.Builder
+	flush := func() {
+		if text.Len() > 0 {
+			nodes = append(nodes, &icuText{Value: text.String()})
+			text.Reset()
+		}
+	}
+
+	for p.pos < len(p.src) {
+		r := p.src[p.pos]
+		switch {
+		case r == '}':
+			if !nested {
+				return nil, fmt.Errorf("位置 %d 存在多余的 }", p.pos)
+			}
+			flush()
+			return nodes, nil
+		case r == '{':
+			flush()
+			node, err := p.parseArgument(inPlural)
+			if err != nil {
+				return nil, err
+			}
+			nodes = append(nodes, node)
+		case r == '#' && inPlural:
+			flush()
+			nodes = append(nodes, &icuPound{})
+			p.pos++
+		case r == '\'':
+			p.parseApostrophe(&text, inPlural)
+		default:
+			text.WriteRune(r)
+			p.pos++
+		}
+	}
+
+	if nested {
+		return nil, fmt.Errorf("缺少 }")
+	}
+	flush()
+	return nodes, nil
+}
+
+// 处理撇号：连续两个撇号表示一个撇号；撇号后紧跟语法字符时开始引用，直到下一个单独的撇号
+func (p *icuParser) parseApostrophe(text *strings.Builder, inPlural bool) {
+	p.pos++
+	if p.pos < len(p.src) && p.src[p.pos] == '\'' {
+		text.WriteRune('\'')
+		p.pos++
+		return
+	}
+	if p.pos >= len(p.src) || !isICUSyntaxChar(p.src[p.pos], inPlural) {
+		text.WriteRune('\'')
+		return
+	}
+	for p.pos < len(p.src) {
+		r := p.src[p.pos]
+		p.pos++
+		if r == '\'' {
+			if p.pos < len(p.src) && p.src[p.pos] == '\'' {
+				text.WriteRune('\'')
+				p.pos++
+				continue
+			}
+			return
+		}
+		text.WriteRune(r)
+	}
+}
+
+// 跳过空白
+func (p *icuParser) skipSpace() {
+	for p.pos < len(p.src) && unicode.IsSpace(p.src[p.pos]) {
+		p.pos++
+	}
+}
+
+// 读取一个单词（参数名、类型或选项名）
+func (p *icuParser) readWord() string {
+	start := p.pos
+	for p.pos < len(p.src) {
+		r := p.src[p.pos]
+		if unicode.IsSpace(r) || r == '{' || r == '}' || r == ',' {
+			break
+		}
+		p.pos++
+	}
+	return string(p.src[start:p.pos])
+}
+
+// 如果下一个字符是 r 则消费它
+func (p *icuParser) consume(r rune) bool {
+	if p.pos < len(p.src) && p.src[p.pos] == r {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+// 解析 { 开始的参数
+func (p *icuParser) parseArgument(inPlural bool) (icuNode, error) {
+	start := p.pos
+	p.pos++
+	p.skipSpace()
+	name := p.readWord()
+	if name == "" {
+		return nil, fmt.Errorf("位置 %d 缺少参数名", start)
+	}
+	p.skipSpace()
+	if p.consume('}') {
+		return &icuArgument{Name: name, Raw: string(p.src[start:p.pos])}, nil
+	}
+	if !p.consume(',') {
+		return nil, fmt.Errorf("参数 %s 格式错误", name)
+	}
+	p.skipSpace()
+	kind := p.readWord()
+	p.skipSpace()
+
+	switch kind {
+	case "plural", "selectordinal", "select":
+		if !p.consume(',') {
+			return nil, fmt.Errorf("参数 %s 缺少选项", name)
+		}
+		return p.parseSelector(name, kind, inPlural)
+	}
+
+	// 其他格式化参数（number、date、time 等）：跳到匹配的 } 并原样保留
+	for depth := 1; p.pos < len(p.src); {
+		switch p.src[p.pos] {
+		case '{':
+			depth++
+		case '}':
+			depth--
+		}
+		p.pos++
+		if depth == 0 {
+			return &icuArgument{Name: name, Raw: string(p.src[start:p.pos])}, nil
+		}
+	}
+	return nil, fmt.Errorf("参数 %s 缺少 }", name)
+}
+
+// 解析 plural/selectordinal/select 的选项列表
+func (p *icuParser) parseSelector(name, kind string, inPlural bool) (*icuSelector, error) {
+	selector := &icuSelector{Name: name, Kind: kind}
+	// select 不改变 # 的含义，复数类选项中的 # 代表当前数值
+	optionInPlural := inPlural || kind != "select"
+
+	for {
+		p.skipSpace()
+		if p.pos >= len(p.src) {
+			return nil, fmt.Errorf("参数 %s 缺少 }", name)
+		}
+		if p.consume('}') {
+			break
+		}
+
+		word := p.readWord()
+		if kind != "select" && strings.HasPrefix(word, "offset:") {
+			selector.Offset = strings.TrimPrefix(word, "offset:")
+			if selector.Offset == "" {
+				p.skipSpace()
+				selector.Offset = p.readWord()
+			}
+			continue
+		}
+		if word == "" {
+			return nil, fmt.Errorf("参数 %s 的选项格式错误", name)
+		}
+
+		p.skipSpace()
+		if !p.consume('{') {
+			return nil, fmt.Errorf("选项 %s 缺少 {", word)
+		}
+		message, err := p.parseMessage(optionInPlural, true)
+		if err != nil {
+			return nil, err
+		}
+		p.pos++
+		selector.Options = append(selector.Options, icuOption{Selector: word, Message: message})
+	}
+
+	if selector.option("other") == nil {
+		return nil, fmt.Errorf("参数 %s 缺少 other 选项", name)
+	}
+	return selector, nil
+}
+
+// 将语法树序列化为 ICU 消息
+func formatICUMessage(nodes []icuNode, inPlural bool) string {
+	var sb strings.Builder
+	for _, node := range nodes {
+		switch n := node.(type) {
+		case *icuText:
+			sb.WriteString(escapeICUText(n.Value, inPlural))
+		case *icuArgument:
+			sb.WriteString(n.Raw)
+		case *icuPound:
+			sb.WriteString("#")
+		case *icuLeaf:
+			sb.WriteString(formatICUMessage(n.Result, inPlural))
+		case *icuSelector:
+			sb.WriteString("{" + n.Name + ", " + n.Kind + ",")
+			if n.Offset != "" {
+				sb.WriteString(" offset:" + n.Offset)
+			}
+			for _, option := range n.Options {
+				sb.WriteString(" " + option.Selector + " {")
+				sb.WriteString(formatICUMessage(option.Message, inPlural || n.Kind != "select"))
+				sb.WriteString("}")
+			}
+			sb.WriteString("}")
+		}
+	}
+	return sb.String()
+}
+
+// 转义字面文本：语法字符用撇号引用，可能被误认为引用开始的撇号写成两个撇号
+func escapeICUText(text string, inPlural bool) string {
+	runes := []rune(text)
+	var sb strings.Builder
+	for i := 0; i < len(runes); i++ {
+		r := runes[i]
+		switch {
+		case isICUSyntaxChar(r, inPlural):
+			sb.WriteRune('\'')
+			for ; i < len(runes) && (isICUSyntaxChar(runes[i], inPlural) || runes[i] == '\''); i++ {
+				if runes[i] == '\'' {
+					sb.WriteString("''")
+				} else {
+					sb.WriteRune(runes[i])
+				}
+			}
+			i--
+			sb.WriteRune('\'')
+		case r == '\'':
+			// 末尾的撇号后面可能紧跟参数的 {，同样需要写成 ''
+			if i+1 == len(runes) || runes[i+1] == '\'' || isICUSyntaxChar(runes[i+1], inPlural) {
+				sb.WriteString("''")
+			} else {
+				sb.WriteRune(r)
+			}
+		default:
+			sb.WriteRune(r)
+		}
+	}
+	return sb.String()
+}
+
+// 检查语法树中是否包含选择器
+func hasICUSelector(nodes []icuNode) bool {
+	for _, node := range nodes {
+		if _, ok := node.(*icuSelector); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// 把选择器提升到消息最外层，让每个选项都是完整的句子，翻译服务能看到完整上下文
+// 例如 "You have {n, plural, one {# file} other {# files}} left"
+// 变为 "{n, plural, one {You have # file left} other {You have # files left}}"
+// outer 为当前所在的复数选择器（# 指向它）
+func hoistICUSelectors(nodes []icuNode, outer *icuSelector) []icuNode {
+	for i, node := range nodes {
+		selector, ok := node.(*icuSelector)
+		if !ok {
+			continue
+		}
+
+		prefix, suffix := nodes[:i], nodes[i+1:]
+		inner := outer
+		if selector.Kind != "select" {
+			// 外层的 # 移入内层复数后会指向内层的数值，改写为显式参数
+			if outer != nil {
+				prefix = poundToArgument(prefix, outer)
+				suffix = poundToArgument(suffix, outer)
+			}
+			inner = selector
+		}
+
+		hoisted := &icuSelector{Name: selector.Name, Kind: selector.Kind, Offset: selector.Offset}
+		for _, option := range selector.Options {
+			message := make([]icuNode, 0, len(prefix)+len(option.Message)+len(suffix))
+			message = append(message, prefix...)
+			message = append(message, option.Message...)
+			message = append(message, suffix...)
+			hoisted.Options = append(hoisted.Options, icuOption{
+				Selector: option.Selector,
+				Message:  hoistICUSelectors(message, inner),
+			})
+		}
+		return []icuNode{hoisted}
+	}
+	return nodes
+}
+
+// 将 # 替换为复数参数本身（select 选项中的 # 也指向同一个复数，一并替换）
+func poundToArgument(nodes []icuNode, plural *icuSelector) []icuNode {
+	result := make([]icuNode, 0, len(nodes))
+	for _, node := range nodes {
+		switch n := node.(type) {
+		case *icuPound:
+			result = append(result, &icuArgument{Name: plural.Name, Raw: "{" + plural.Name + ", number}"})
+		case *icuSelector:
+			if n.Kind != "select" {
+				result = append(result, n)
+				continue
+			}
+			converted := &icuSelector{Name: n.Name, Kind: n.Kind}
+			for _, option := range n.Options {
+				converted.Options = append(converted.Options, icuOption{Selector: option.Selector, Message: poundToArgument(option.Message, plural)})
+			}
+			result = append(result, converted)
+		default:
+			result = append(result, n)
+		}
+	}
+	return result
+}
+
+// CLDR 基数复数类别（按语言），未列出的语言沿用原文的选项
+var cardinalPluralCategories = map[string][]string{
+	"en": {"one", "other"},
+	"de": {"one", "other"},
+	"nl": {"one", "other"},
+	"sv": {"one", "other"},
+	"da": {"one", "other"},
+	"fi": {"one", "other"},
+	"no": {"one", "other"},
+	"nb": {"one", "other"},
+	"it": {"one", "many", "other"},
+	"es": {"one", "many", "other"},
+	"pt": {"one", "many", "other"},
+	"tr": {"one", "other"},
+	"fr": {"one", "many", "other"},
+	"zh": {"other"},
+	"ja": {"other"},
+	"ko": {"other"},
+	"ar": {"zero", "one", "two", "few", "many", "other"},
+	"pl": {"one", "few", "many", "other"},
+	"ru": {"one", "few", "many", "other"},
+}
+
+// CLDR 序数复数类别（selectordinal）
+var ordinalPluralCategories = map[string][]string{
+	"en": {"one", "two", "few", "other"},
+	"fr": {"one", "other"},
+	"it": {"many", "other"},
+	"sv": {"one", "other"},
+	"de": {"other"},
+	"nl": {"other"},
+	"da": {"other"},
+	"fi": {"other"},
+	"no": {"other"},
+	"nb": {"other"},
+	"es": {"other"},
+	"pt": {"other"},
+	"tr": {"other"},
+	"zh": {"other"},
+	"ja": {"other"},
+	"ko": {"other"},
+	"ar": {"other"},
+	"pl": {"other"},
+	"ru": {"other"},
+}
+
+// 各类别的示例数字：翻译时用它代替 #，让翻译服务写出对应的词形（如波兰语 3 plików / 5 plików）
+// 每个类别有多个候选，避免与文本中已有的数字冲突；"" 为默认值
+var cardinalPluralExamples = map[string]map[string][]string{
+	"":   {"zero": {"0"}, "one": {"1"}, "two": {"2"}, "few": {"3", "4"}, "many": {"5", "6"}, "other": {"7", "8", "9"}},
+	"pl": {"one": {"1"}, "few": {"3", "4", "2"}, "many": {"5", "6", "7"}, "other": {"1.5", "2.5"}},
+	"ru": {"one": {"1", "21"}, "few": {"3", "4", "2"}, "many": {"5", "6", "7"}, "other": {"1.5", "2.5"}},
+	"ar": {"zero": {"0"}, "one": {"1"}, "two": {"2"}, "few": {"3", "4", "5"}, "many": {"11", "12", "13"}, "other": {"100", "101"}},
+	"fr": {"one": {"1"}, "many": {"1000000", "2000000"}, "other": {"7", "8", "9"}},
+	"it": {"one": {"1"}, "many": {"1000000", "2000000"}, "other": {"7", "8", "9"}},
+	"es": {"one": {"1"}, "many": {"1000000", "2000000"}, "other": {"7", "8", "9"}},
+	"pt": {"one": {"1"}, "many": {"1000000", "2000000"}, "other": {"7", "8", "9"}},
+}
+
+var ordinalPluralExamples = map[string]map[string][]string{
+	"":   {"one": {"1"}, "two": {"2"}, "few": {"3"}, "many": {"8"}, "other": {"5", "6", "7"}},
+	"en": {"one": {"1", "21"}, "two": {"2", "22"}, "few": {"3", "23"}, "other": {"4", "5", "6"}},
+	"fr": {"one": {"1"}, "other": {"2", "3"}},
+	"it": {"many": {"8", "11"}, "other": {"1", "2", "3"}},
+	"sv": {"one": {"1", "2"}, "other": {"3", "4"}},
+}
+
+// 内部语言代码（如 "PT-BR"）对应的 CLDR 语言
+func pluralLanguage(lang string) string {
+	return strings.ToLower(strings.SplitN(lang, "-", 2)[0])
+}
+
+// 目标语言的复数类别，未知语言返回 nil
+func pluralCategoriesFor(lang, kind string) []string {
+	if kind == "selectordinal" {
+		return ordinalPluralCategories[pluralLanguage(lang)]
+	}
+	return cardinalPluralCategories[pluralLanguage(lang)]
+}
+
+// 选择类别的示例数字，所有候选都出现在文本中时返回空
+func pluralExample(lang, kind, category, text string) string {
+	candidates := []string{strings.TrimPrefix(category, "=")}
+	if !strings.HasPrefix(category, "=") {
+		table := cardinalPluralExamples
+		if kind == "selectordinal" {
+			table = ordinalPluralExamples
+		}
+		candidates = table[pluralLanguage(lang)][category]
+		if candidates == nil {
+			candidates = table[""][category]
+		}
+	}
+	for _, candidate := range candidates {
+		if !strings.Contains(text, candidate) {
+			return candidate
+		}
+	}
+	return ""
+}
+
+// 按目标语言的复数类别重建选项：精确匹配（=0）保留，每个类别优先使用原文同名选项，否则使用 other
+func remapPluralOptions(selector *icuSelector, lang string) []icuOption {
+	categories := pluralCategoriesFor(lang, selector.Kind)
+	if categories == nil {
+		return selector.Options
+	}
+
+	options := []icuOption{}
+	for _, option := range selector.Options {
+		if strings.HasPrefix(option.Selector, "=") {
+			options = append(options, option)
+		}
+	}
+	for _, category := range categories {
+		source := selector.option(category)
+		if source == nil {
+			source = selector.option("other")
+		}
+		options = append(options, icuOption{Selector: category, Message: source.Message})
+	}
+	return options
+}
+
+// ICU 消息的翻译计划：提升选择器后，每个叶子（不含选择器的完整句子）单独翻译
+type icuPlan struct {
+	Nodes  []icuNode
+	Leaves []*icuLeaf
+}
+
+// 待翻译的叶子句子
+type icuLeaf struct {
+	Source   []icuNode // 原文节点（文本、参数、#）
+	Label    string    // 所在的选项，如 "count=few"，作为翻译上下文
+	Number   string    // 代替 # 的示例数字（叶子不含 # 或没有可用数字时为空）
+	Pound    string    // 回退时代替 # 的参数，如 {count}
+	Fragment string    // 发送给翻译服务的文本
+	Fallback string    // 示例数字没有出现在译文中时改用的文本
+	Result   []icuNode // 还原后的译文节点
+}
+
+// 为包含 plural/select 的 ICU 消息生成翻译计划；普通文本返回 nil
+func planICUMessage(text, lang string) *icuPlan {
+	if !strings.Contains(text, ",") {
+		return nil
+	}
+	nodes, err := parseICUMessage(text)
+	if err != nil || !hasICUSelector(nodes) {
+		return nil
+	}
+	plan := &icuPlan{}
+	plan.Nodes = plan.build(hoistICUSelectors(nodes, nil), lang, nil, "", "")
+	return plan
+}
+
+// 按目标语言重建语法树，叶子替换为 *icuLeaf
+// plural 和 category 为叶子所在的复数选择器及其类别（决定 # 的示例数字）
+func (plan *icuPlan) build(nodes []icuNode, lang string, plural *icuSelector, category, label string) []icuNode {
+	if len(nodes) == 1 {
+		if selector, ok := nodes[0].(*icuSelector); ok {
+			options := selector.Options
+			if selector.Kind != "select" {
+				options = remapPluralOptions(selector, lang)
+			}
+
+			rebuilt := &icuSelector{Name: selector.Name, Kind: selector.Kind, Offset: selector.Offset}
+			for _, option := range options {
+				innerPlural, innerCategory := plural, category
+				if selector.Kind != "select" {
+					innerPlural, innerCategory = selector, option.Selector
+				}
+				innerLabel := selector.Name + "=" + option.Selector
+				if label != "" {
+					innerLabel = label + ", " + innerLabel
+				}
+				rebuilt.Options = append(rebuilt.Options, icuOption{
+					Selector: option.Selector,
+					Message:  plan.build(option.Message, lang, innerPlural, innerCategory, innerLabel),
+				})
+			}
+			return []icuNode{rebuilt}
+		}
+	}
+
+	leaf := newICULeaf(nodes, lang, plural, category, label)
+	plan.Leaves = append(plan.Leaves, leaf)
+	return []icuNode{leaf}
+}
+
+// 创建叶子并生成待翻译文本
+func newICULeaf(nodes []icuNode, lang string, plural *icuSelector, category, label string) *icuLeaf {
+	leaf := &icuLeaf{Source: nodes, Label: label}
+	if plural != nil && countICUPounds(nodes) > 0 {
+		leaf.Number = pluralExample(lang, plural.Kind, category, icuLeafText(nodes, ""))
+		// 回退参数不能与叶子中已有的参数重名
+		leaf.Pound = "{" + plural.Name + "}"
+		for strings.Contains(icuLeafText(nodes, ""), leaf.Pound) {
+			leaf.Pound = leaf.Pound[:len(leaf.Pound)-1] + "_}"
+		}
+	}
+
+	leaf.Fallback = icuLeafText(nodes, leaf.Pound)
+	leaf.Fragment = leaf.Fallback
+	if leaf.Number != "" {
+		leaf.Fragment = icuLeafText(nodes, leaf.Number)
+	}
+	return leaf
+}
+
+// 统计 # 的数量
+func countICUPounds(nodes []icuNode) int {
+	count := 0
+	for _, node := range nodes {
+		if _, ok := node.(*icuPound); ok {
+			count++
+		}
+	}
+	return count
+}
+
+// 将叶子节点拼接为纯文本，# 替换为 pound
+func icuLeafText(nodes []icuNode, pound string) string {
+	var sb strings.Builder
+	for _, node := range nodes {
+		switch n := node.(type) {
+		case *icuText:
+			sb.WriteString(n.Value)
+		case *icuArgument:
+			sb.WriteString(n.Raw)
+		case *icuPound:
+			sb.WriteString(pound)
+		}
+	}
+	return sb.String()
+}
+
+// 将译文还原为节点：原文中的参数按原样识别，pound（示例数字或回退参数）还原为 #
+// # 的数量与原文不一致时返回 false
+func (leaf *icuLeaf) resolve(translated, pound string) bool {
+	arguments := []string{}
+	for _, node := range leaf.Source {
+		if argument, ok := node.(*icuArgument); ok {
+			arguments = append(arguments, argument.Raw)
+		}
+	}
+	sort.SliceStable(arguments, func(i, j int) bool { return len(arguments[i]) > len(arguments[j]) })
+	numeric := pound != "" && !strings.HasPrefix(pound, "{")
+
+	nodes := []icuNode{}
+	var text strings.Builder
+	flush := func() {
+		if text.Len() > 0 {
+			nodes = append(nodes, &icuText{Value: text.String()})
+			text.Reset()
+		}
+	}
+
+	pounds := 0
+	for i := 0; i < len(translated); {
+		rest := translated[i:]
+		if pound != "" && strings.HasPrefix(rest, pound) && (!numeric || isNumberBoundary(translated, i, i+len(pound))) {
+			flush()
+			nodes = append(nodes, &icuPound{})
+			pounds++
+			i += len(pound)
+			continue
+		}
+
+		matched := false
+		for _, argument := range arguments {
+			if strings.HasPrefix(rest, argument) {
+				flush()
+				nodes = append(nodes, &icuArgument{Raw: argument})
+				i += len(argument)
+				matched = true
+				break
+			}
+		}
+		if matched {
+			continue
+		}
+
+		_, size := utf8.DecodeRuneInString(rest)
+		text.WriteString(rest[:size])
+		i += size
+	}
+	flush()
+
+	if pounds != countICUPounds(leaf.Source) {
+		return false
+	}
+	leaf.Result = nodes
+	return true
+}
+
+// 检查 [start, end) 两侧不是数字（避免把 "11" 中的 "1" 当作示例数字）
+func isNumberBoundary(text string, start, end int) bool {
+	if start > 0 {
+		if r, _ := utf8.DecodeLastRuneInString(text[:start]); unicode.IsDigit(r) {
+			return false
+		}
+	}
+	if end < len(text) {
+		if r, _ := utf8.DecodeRuneInString(text[end:]); unicode.IsDigit(r) {
+			return false
+		}
+	}
+	return true
+}
+
+// 保持键顺序的 JSON 对象
+// encoding/json 解码到 map 会丢失键顺序，导致输出文件按字母排序，与 messages/en 不一致
+type OrderedMap struct {
+	Keys   []string
+	Values map[string]interface{}
+
+	// 键前面的空行（原样保留源文件中的空白行）
+	gaps map[string]string
+}
+
+// 创建空的有序对象
+func NewOrderedMap() *OrderedMap {
+	return &OrderedMap{Values: make(map[string]interface{})}
+}
+
+// 读取键值
+func (m *OrderedMap) Get(key string) (interface{}, bool) {
+	value, ok := m.Values[key]
+	return value, ok
+}
+
+// 复制另一个对象的空行布局（用于生成与源文件格式一致的译文）
+func (m *OrderedMap) copyLayout(other *OrderedMap) {
+	m.gaps = other.gaps
+}
+
+// 设置键值：新键追加到末尾，已有的键保持原位置
+func (m *OrderedMap) Set(key string, value interface{}) {
+	if _, ok := m.Values[key]; !ok {
+		m.Keys = append(m.Keys, key)
+	}
+	m.Values[key] = value
+}
+
+// JSON 文件的格式信息（缩进和末尾空白），写回时与源文件保持一致
+type JSONFormat struct {
+	Indent   string
+	Trailing string
+}
+
+// 默认格式：两个空格缩进，无末尾换行（与旧版脚本输出一致）
+var defaultJSONFormat = JSONFormat{Indent: "  "}
+
+// 从原始内容中检测缩进和末尾空白
+func detectJSONFormat(data []byte) JSONFormat {
+	format := defaultJSONFormat
+	format.Trailing = string(data[len(bytes.TrimRight(data, " \t\r\n")):])
+
+	for _, line := range strings.Split(string(data), "\n") {
+		trimmed := strings.TrimLeft(line, " \t")
+		if len(trimmed) < len(line) && strings.TrimSpace(trimmed) != "" {
+			format.Indent = line[:len(line)-len(trimmed)]
+			break
+		}
+	}
+	return format
+}
+
+// 解析 JSON 并保持对象键顺序
+// 对象解析为 *OrderedMap，数组为 []interface{}，数字保留为 json.Number（原样输出）
+func decodeOrderedJSON(data []byte) (interface{}, error) {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+
+	value, err := decodeOrderedValue(decoder, data)
+	if err != nil {
+		return nil, err
+	}
+
+	// 确保没有多余的内容
+	if _, err := decoder.Token(); err != io.EOF {
+		return nil, fmt.Errorf("JSON 末尾存在多余内容")
+	}
+	return value, nil
+}
+
+// 提取键前面的空行：raw 为上一个 token 结束到当前键结束之间的原始内容
+// 例如 ",\n    \n    \"key\"" 返回 "    \n"（多出的一行）
+func blankLinesBefore(raw []byte) string {
+	quote := bytes.IndexByte(raw, '"')
+	if quote < 0 {
+		return ""
+	}
+	prefix := string(raw[:quote])
+	first := strings.Index(prefix, "\n")
+	last := strings.LastIndex(prefix, "\n")
+	if first < 0 || first == last {
+		return ""
+	}
+	return prefix[first+1 : last+1]
+}
+
+// 递归读取一个 JSON 值
+func decodeOrderedValue(decoder *json.Decoder, data []byte) (interface{}, error) {
+	token, err := decoder.Token()
+	if err != nil {
+		return nil, err
+	}
+
+	switch t := token.(type) {
+	case json.Delim:
+		switch t {
+		case '{':
+			object := NewOrderedMap()
+			// 记录上一个 token 的结束位置（More 会跳过空白，需要提前记录）
+			offset := decoder.InputOffset()
+			for decoder.More() {
+				keyToken, err := decoder.Token()
+				if err != nil {
+					return nil, err
+				}
+				key, ok := keyToken.(string)
+				if !ok {
+					return nil, fmt.Errorf("对象键必须是字符串: %v", keyToken)
+				}
+				if gap := blankLinesBefore(data[offset:decoder.InputOffset()]); gap != "" {
+					if object.gaps == nil {
+						object.gaps = make(map[string]string)
+					}
+					object.gaps[key] = gap
+				}
+				value, err := decodeOrderedValue(decoder, data)
+				if err != nil {
+					return nil, err
+				}
+				object.Set(key, value)
+				offset = decoder.InputOffset()
+			}
+			// 读取结束符 '}'
+			if _, err := decoder.Token(); err != nil {
+				return nil, err
+			}
+			return object, nil
+		case '[':
+			array := []interface{}{}
+			for decoder.More() {
+				value, err := decodeOrderedValue(decoder, data)
+				if err != nil {
+					return nil, err
+				}
+				array = append(array, value)
+			}
+			// 读取结束符 ']'
+			if _, err := decoder.Token(); err != nil {
+				return nil, err
+			}
+			return array, nil
+		default:
+			return nil, fmt.Errorf("意外的分隔符: %v", t)
+		}
+	default:
+		// string、json.Number、bool、nil
+		return t, nil
+	}
+}
+
+// 将有序 JSON 树序列化为带缩进的文本（不做 HTML 转义）
+func encodeOrderedJSON(value interface{}, format JSONFormat) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := writeOrderedValue(&buf, value, format.Indent, 0); err != nil {
+		return nil, err
+	}
+	buf.WriteString(format.Trailing)
+	return buf.Bytes(), nil
+}
+
+// 递归写入一个 JSON 值
+func writeOrderedValue(buf *bytes.Buffer, value interface{}, indent string, depth int) error {
+	switch v := value.(type) {
+	case *OrderedMap:
+		if len(v.Keys) == 0 {
+			buf.WriteString("{}")
+			return nil
+		}
+		buf.WriteString("{\n")
+		for i, key := range v.Keys {
+			buf.WriteString(v.gaps[key])
+			buf.WriteString(strings.Repeat(indent, depth+1))
+			if err := writeJSONString(buf, key); err != nil {
+				return err
+			}
+			buf.WriteString(": ")
+			if err := writeOrderedValue(buf, v.Values[key], indent, depth+1); err != nil {
+				return err
+			}
+			if i < len(v.Keys)-1 {
+				buf.WriteByte(',')
+			}
+			buf.WriteByte('\n')
+		}
+		buf.WriteString(strings.Repeat(indent, depth))
+		buf.WriteByte('}')
+	case []interface{}:
+		if len(v) == 0 {
+			buf.WriteString("[]")
+			return nil
+		}
+		buf.WriteString("[\n")
+		for i, item := range v {
+			buf.WriteString(strings.Repeat(indent, depth+1))
+			if err := writeOrderedValue(buf, item, indent, depth+1); err != nil {
+				return err
+			}
+			if i < len(v)-1 {
+				buf.WriteByte(',')
+			}
+			buf.WriteByte('\n')
+		}
+		buf.WriteString(strings.Repeat(indent, depth))
+		buf.WriteByte(']')
+	case string:
+		return writeJSONString(buf, v)
+	case json.Number:
+		buf.WriteString(v.String())
+	case bool:
+		if v {
+			buf.WriteString("true")
+		} else {
+			buf.WriteString("false")
+		}
+	case nil:
+		buf.WriteString("null")
+	default:
+		return fmt.Errorf("不支持的 JSON 值类型: %T", value)
+	}
+	return nil
+}
+
+// 写入 JSON 字符串（禁用 HTML 转义，保持 <、>、& 原样）
+func writeJSONString(buf *bytes.Buffer, s string) error {
+	var tmp bytes.Buffer
+	encoder := json.NewEncoder(&tmp)
+	encoder.SetEscapeHTML(false)
+	if err := encoder.Encode(s); err != nil {
+		return err
+	}
+	buf.Write(bytes.TrimSuffix(tmp.Bytes(), []byte("\n")))
+	return nil
+}
+
+// 待翻译文本集合：按首次出现的顺序保存文本，并记录每个文本出现的 JSON 键路径
+type TextCollection struct {
+	Order    []string
+	KeyPaths map[string][]string
+}
+
+// 创建空的文本集合
+func NewTextCollection() *TextCollection {
+	return &TextCollection{KeyPaths: make(map[string][]string)}
+}
+
+// 添加文本及其键路径
+func (c *TextCollection) Add(text, path string) {
+	if _, ok := c.KeyPaths[text]; !ok {
+		c.Order = append(c.Order, text)
+	}
+	c.KeyPaths[text] = append(c.KeyPaths[text], path)
+}
+
+// 第一步：收集所有需要翻译的文本，以及每个文本出现的 JSON 键路径（如 "tiers.pro.description"）
+func collectTexts(data interface{}, path string, texts *TextCollection) {
+	switch v := data.(type) {
+	case *OrderedMap:
+		for _, key := range v.Keys {
+			collectTexts(v.Values[key], joinKeyPath(path, key), texts)
+		}
+	case []interface{}:
+		for i, value := range v {
+			collectTexts(value, joinKeyPath(path, fmt.Sprintf("%d", i)), texts)
+		}
+	case string:
+		if len(v) > 0 && !isPlaceholder(v) {
+			texts.Add(v, path)
+		}
+	}
+}
+
+// 拼接 JSON 键路径
+func joinKeyPath(prefix, key string) string {
+	if prefix == "" {
+		return key
+	}
+	return prefix + "." + key
+}
+
+// 第二步：递归替换翻译后的文本（保持键顺序）
+func translateJSON(data interface{}, translations map[string]string) interface{} {
+	switch v := data.(type) {
+	case *OrderedMap:
+		result := NewOrderedMap()
+		result.copyLayout(v)
+		for _, key := range v.Keys {
+			result.Set(key, translateJSON(v.Values[key], translations))
+		}
+		return result
+	case []interface{}:
+		result := make([]interface{}, len(v))
+		for i, value := range v {
+			result[i] = translateJSON(value, translations)
+		}
+		return result
+	case string:
+		if len(v) == 0 || isPlaceholder(v) {
+			return v
+		}
+		if translated, ok := translations[v]; ok {
+			return translated
+		}
+		return v
+	default:
+		return v
+	}
+}
+
+// 读取目标文件中已有的翻译（键路径 -> 译文），文件不存在时返回空表
+func loadExistingTranslations(targetFile string) (map[string]string, error) {
+	existing := make(map[string]string)
+
+	data, err := ioutil.ReadFile(targetFile)
+	if os.IsNotExist(err) {
+		return existing, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("读取目标文件失败: %v", err)
+	}
+
+	existingData, err := decodeOrderedJSON(data)
+	if err != nil {
+		return nil, fmt.Errorf("解析目标文件失败: %v", err)
+	}
+	flattenStrings(existingData, "", existing)
+	return existing, nil
+}
+
+// 将 JSON 树中的所有字符串展开为 键路径 -> 值
+func flattenStrings(data interface{}, path string, out map[string]string) {
+	switch v := data.(type) {
+	case *OrderedMap:
+		for _, key := range v.Keys {
+			flattenStrings(v.Values[key], joinKeyPath(path, key), out)
+		}
+	case []interface{}:
+		for i, value := range v {
+			flattenStrings(value, joinKeyPath(path, fmt.Sprintf("%d", i)), out)
+		}
+	case string:
+		out[path] = v
+	}
+}
+
+// 增量过滤：目标文件已有译文、且原文哈希与锁文件一致的键保留原译文，其余键重新翻译
+func filterIncremental(texts *TextCollection, existing map[string]string, lock *TranslationLock) (*TextCollection, map[string]string) {
+	remaining := NewTextCollection()
+	kept := make(map[string]string)
+
+	for _, text := range texts.Order {
+		for _, path := range texts.KeyPaths[text] {
+			translated, hasTranslation := existing[path]
+			if hasTranslation && translated != "" && !isStale(lock, path, text) {
+				kept[path] = translated
+				continue
+			}
+			remaining.Add(text, path)
+		}
+	}
+
+	return remaining, kept
+}
+
+// 将保留的已有译文按键路径写回 JSON 树
+func applyKeptTranslations(data interface{}, path string, kept map[string]string) interface{} {
+	if len(kept) == 0 {
+		return data
+	}
+
+	switch v := data.(type) {
+	case *OrderedMap:
+		for _, key := range v.Keys {
+			v.Values[key] = applyKeptTranslations(v.Values[key], joinKeyPath(path, key), kept)
+		}
+		return v
+	case []interface{}:
+		for i, value := range v {
+			v[i] = applyKeptTranslations(value, joinKeyPath(path, fmt.Sprintf("%d", i)), kept)
+		}
+		return v
+	case string:
+		if translated, ok := kept[path]; ok {
+			return translated
+		}
+		return v
+	default:
+		return v
+	}
+}
+
+// 处理单个文件
+// stats 为该文件所属语言的统计（可为 nil）
+func processFile(sourceFile, targetDir string, translator Translator, targetLang string, stats *TranslationStats) error {
+	fileName := filepath.Base(sourceFile)
+	fmt.Printf("\n📄 处理文件: %s/%s\n", filepath.Base(targetDir), fileName)
+
+	// 加载该文件的缓存
+	fileCache := loadFileCache(targetDir, fileName)
+	targetFile := filepath.Join(targetDir, fileName)
+
+	// 读取源文件
+	data, err := ioutil.ReadFile(sourceFile)
+	if err != nil {
+		return fmt.Errorf("读取文件失败: %v", err)
+	}
+
+	// 解析 JSON（保持键顺序）
+	jsonData, err := decodeOrderedJSON(data)
+	if err != nil {
+		return fmt.Errorf("解析 JSON 失败: %v", err)
+	}
+
+	// 第一步：收集所有需要翻译的文本
+	textsToTranslate := NewTextCollection()
+	collectTexts(jsonData, "", textsToTranslate)
+
+	// 加载翻译锁文件（记录每个键翻译时的原文哈希）
+	lock, err := loadTranslationLock(targetDir, fileName)
+	if err != nil {
+		return err
+	}
+
+	// 增量模式：保留已有翻译，只翻译缺失或原文哈希已变化的键
+	var keptValues map[string]string
+	if incrementalMode {
+		existing, err := loadExistingTranslations(targetFile)
+		if err != nil {
+			return err
+		}
+		textsToTranslate, keptValues = filterIncremental(textsToTranslate, existing, lock)
+		fmt.Printf("♻️  增量模式: 保留 %d 个已有翻译，需要翻译 %d 个文本\n", len(keptValues), len(textsToTranslate.Order))
+	}
+
+	// 第二步：批量翻译
+	failures := make(map[string]string)
+	translations, err := translateMessages(translator, textsToTranslate, targetLang, fileCache.Entries, stats, failures)
+	if err != nil {
+		return fmt.Errorf("翻译失败: %v", err)
+	}
+
+	// 检测疑似未翻译的译文（仍会写入），可选跳过缓存重新翻译一次
+	// 简繁转换的源文本是中文，只检查是否残留简体字（转换结果是确定的，不重试）
+	locale := filepath.Base(targetDir)
+	_, converting := translator.(*ChineseConverter)
+	var suspects map[string]string
+	if converting {
+		suspects = findUnconverted(textsToTranslate, translations, targetLang)
+	} else {
+		suspects = findUntranslated(textsToTranslate, translations, targetLang)
+	}
+	if len(suspects) > 0 && retryUntranslated && !converting {
+		suspects, err = retryUntranslatedTexts(translator, textsToTranslate, suspects, translations, targetLang, fileCache.Entries, stats)
+		if err != nil {
+			return fmt.Errorf("重新翻译失败: %v", err)
+		}
+	}
+	for _, text := range textsToTranslate.Order {
+		if reason, ok := suspects[text]; ok {
+			for _, path := range textsToTranslate.KeyPaths[text] {
+				untranslatedWarnings.Record(ValidationFailure{Locale: locale, File: fileName, Key: path, Reason: reason})
+			}
+		}
+	}
+
+	// 检查长度限制（仍会写入），LLM 服务可选请模型缩短
+	namespace := strings.TrimSuffix(fileName, ".json")
+	overLength := findOverLength(textsToTranslate, translations, namespace)
+	if st, ok := translator.(ShorteningTranslator); ok && len(overLength) > 0 && shortenOverLength {
+		overLength, err = shortenTranslations(st, textsToTranslate, overLength, translations, targetLang, namespace, fileCache.Entries, stats)
+		if err != nil {
+			return fmt.Errorf("缩短译文失败: %v", err)
+		}
+	}
+	for _, text := range textsToTranslate.Order {
+		if _, ok := overLength[text]; !ok {
+			continue
+		}
+		for _, path := range textsToTranslate.KeyPaths[text] {
+			rule := lengthRuleFor(namespace, path)
+			if rule == nil {
+				continue
+			}
+			if length := rule.measure(translations[text]); length > rule.Max {
+				violation := lengthViolation{Rule: rule, Length: length}
+				lengthViolations.Record(ValidationFailure{Locale: locale, File: fileName, Key: path, Reason: violation.String()})
+			}
+		}
+	}
+
+	// 校验失败的键保留目标文件中原有的译文（没有原有译文时暂用英文原文），并记录到最终汇总
+	failedPaths := make(map[string]bool)
+	if len(failures) > 0 {
+		previous, err := loadExistingTranslations(targetFile)
+		if err != nil {
+			return err
+		}
+		if keptValues == nil {
+			keptValues = make(map[string]string)
+		}
+		for _, text := range textsToTranslate.Order {
+			reason, failed := failures[text]
+			if !failed {
+				continue
+			}
+			for _, path := range textsToTranslate.KeyPaths[text] {
+				failedPaths[path] = true
+				if value := previous[path]; value != "" {
+					keptValues[path] = value
+				}
+				validationFailures.Record(ValidationFailure{Locale: locale, File: fileName, Key: path, Reason: reason})
+			}
+		}
+	}
+
+	// 第三步：递归替换翻译后的文本，再放回保留的已有翻译
+	translatedData := translateJSON(jsonData, translations)
+	translatedData = applyKeptTranslations(translatedData, "", keptValues)
+
+	// 转换回 JSON，键顺序、缩进和末尾换行与源文件一致
+	translated, err := encodeOrderedJSON(translatedData, detectJSONFormat(data))
+	if err != nil {
+		return fmt.Errorf("序列化 JSON 失败: %v", err)
+	}
+
+	// 确保目标目录存在
+	if err := os.MkdirAll(targetDir, 0755); err != nil {
+		return fmt.Errorf("创建目录失败: %v", err)
+	}
+
+	// 写入目标文件
+	if err := ioutil.WriteFile(targetFile, translated, 0644); err != nil {
+		return fmt.Errorf("写入文件失败: %v", err)
+	}
+
+	fmt.Printf("✅ 已保存: %s\n", targetFile)
+
+	// 保存该文件的缓存
+	if err := saveFileCache(targetDir, fileName, fileCache); err != nil {
+		fmt.Printf("⚠️  缓存保存失败: %v\n", err)
+	}
+
+	// 更新锁文件：记录写入的每个键对应的原文哈希
+	sourceTexts := make(map[string]string)
+	flattenStrings(jsonData, "", sourceTexts)
+	previousHashes := lock.Hashes
+	lock.Hashes = make(map[string]string, len(sourceTexts))
+	for path, text := range sourceTexts {
+		if failedPaths[path] {
+			// 校验失败的键：保留原有译文对应的哈希；没有原有译文时记录为空，下次增量翻译会重试
+			lock.Hashes[path] = ""
+			if _, kept := keptValues[path]; kept {
+				lock.Hashes[path] = previousHashes[path]
+			}
+			continue
+		}
+		lock.Hashes[path] = sourceHash(text)
+	}
+	if err := saveTranslationLock(targetDir, fileName, lock); err != nil {
+		fmt.Printf("⚠️  锁文件保存失败: %v\n", err)
+	}
+
+	return nil
+}
+
+// 单个文件翻译任务
+type fileJob struct {
+	SourceFile string
+	TargetDir  string
+	Lang       string
+	Stats      *TranslationStats
+}
+
+// 文件任务的执行结果
+type fileJobResult struct {
+	Job      fileJob
+	Err      error
+	Started  time.Time
+	Finished time.Time
+}
+
+// 使用固定数量的 worker 并发执行文件任务（跨文件、跨语言共享同一个池）
+// 结果顺序与任务顺序一致
+func runFileJobs(jobs []fileJob, translator Translator, concurrency int) []fileJobResult {
+	if concurrency < 1 {
+		concurrency = 1
+	}
+
+	results := make([]fileJobResult, len(jobs))
+	indexes := make(chan int)
+	var wg sync.WaitGroup
+
+	for w := 0; w < concurrency; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range indexes {
+				job := jobs[i]
+				started := time.Now()
+				err := processFile(job.SourceFile, job.TargetDir, translator, job.Lang, job.Stats)
+				if err != nil {
+					fmt.Printf("❌ 错误 (%s/%s): %v\n", filepath.Base(job.TargetDir), filepath.Base(job.SourceFile), err)
+				}
+				results[i] = fileJobResult{Job: job, Err: err, Started: started, Finished: time.Now()}
+			}
+		}()
+	}
+
+	for i := range jobs {
+		indexes <- i
+	}
+	close(indexes)
+	wg.Wait()
+
+	return results
+}
+
+// 列出目录中的 JSON 文件，为每个文件创建翻译任务
+func listFileJobs(sourceDir, targetDir, targetLang string, stats *TranslationStats) ([]fileJob, error) {
+	files, err := ioutil.ReadDir(sourceDir)
+	if err != nil {
+		return nil, fmt.Errorf("读取目录失败: %v", err)
+	}
+
+	jobs := []fileJob{}
+	for _, file := range files {
+		if !file.IsDir() && strings.HasSuffix(file.Name(), ".json") {
+			jobs = append(jobs, fileJob{
+				SourceFile: filepath.Join(sourceDir, file.Name()),
+				TargetDir:  targetDir,
+				Lang:       targetLang,
+				Stats:      stats,
+			})
+		}
+	}
+	return jobs, nil
+}
+
+// 批量处理目录，返回成功和失败的文件数量
+func processDirectory(sourceDir, targetDir string, translator Translator, targetLang string) (int, int, error) {
+	jobs, err := listFileJobs(sourceDir, targetDir, targetLang, nil)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	fmt.Printf("📂 找到 %d 个文件\n\n", len(jobs))
+
+	succeeded, failed := 0, 0
+	for _, result := range runFileJobs(jobs, translator, workerCount) {
+		if result.Err != nil {
+			failed++
+			continue
+		}
+		succeeded++
+	}
+
+	return succeeded, failed, nil
+}
+
+// 单个语言的翻译结果汇总
+type LocaleSummary struct {
+	Locale      string
+	Lang        string
+	Files       int
+	Failed      int
+	Requests    int
+	CacheHits   int
+	CacheMisses int
+	Elapsed     time.Duration
+	Err         error
+}
+
+// 需要在最终汇总中列出的键（译文校验失败、疑似未翻译等）
+type ValidationFailure struct {
+	Locale string
+	File   string
+	Key    string
+	Reason string
+}
+
+// 按键记录的问题列表（多个 worker 并发写入）
+type keyIssueLog struct {
+	mu    sync.Mutex
+	items []ValidationFailure
+}
+
+// 所有校验失败的键
+var validationFailures keyIssueLog
+
+// 疑似未翻译的键
+var untranslatedWarnings keyIssueLog
+
+// 超出长度限制的键
+var lengthViolations keyIssueLog
+
+// 记录一个键
+func (l *keyIssueLog) Record(failure ValidationFailure) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.items = append(l.items, failure)
+}
+
+// 统计某个语言的键数量
+func (l *keyIssueLog) Count(locale string) int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	count := 0
+	for _, failure := range l.items {
+		if failure.Locale == locale {
+			count++
+		}
+	}
+	return count
+}
+
+// 按语言、文件和键排序输出所有记录，title 中的 %d 为数量
+func (l *keyIssueLog) Print(title string) {
+	l.mu.Lock()
+	failures := append([]ValidationFailure(nil), l.items...)
+	l.mu.Unlock()
+	if len(failures) == 0 {
+		return
+	}
+
+	sort.Slice(failures, func(i, j int) bool {
+		a, b := failures[i], failures[j]
+		if a.Locale != b.Locale {
+			return a.Locale < b.Locale
+		}
+		if a.File != b.File {
+			return a.File < b.File
+		}
+		return a.Key < b.Key
+	})
+
+	fmt.Printf("\n"+title+"\n", len(failures))
+	for _, failure := range failures {
+		fmt.Printf("  - %s/%s %s: %s\n", failure.Locale, failure.File, failure.Key, failure.Reason)
+	}
+}
+
+// 语言配置文件（按顺序查找）
+var localeConfigPaths = []string{"./config/locales.js", "./i18n/config.ts"}
+
+// 语言配置文件中的 locales 数组、defaultLocale 和数组中的字符串
+var (
+	localesPattern = regexp.MustCompile(`(?s)\blocales\s*=\s*\[(.*?)\]`)
+	defaultPattern = regexp.MustCompile(`\bdefaultLocale\s*(?::\s*\w+\s*)?=\s*['"]([^'"]+)['"]`)
+	itemPattern    = regexp.MustCompile(`['"]([^'"]+)['"]`)
+)
+
+// 从 config/locales.js 或 i18n/config.ts 中读取语言列表和默认语言
+func loadLocaleConfig(paths []string) ([]string, string, error) {
+	for _, path := range paths {
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			continue
+		}
+
+		match := localesPattern.FindSubmatch(data)
+		if match == nil {
+			continue
+		}
+		locales := []string{}
+		for _, item := range itemPattern.FindAllSubmatch(match[1], -1) {
+			locales = append(locales, string(item[1]))
+		}
+
+		defaultLocale := "en"
+		if m := defaultPattern.FindSubmatch(data); m != nil {
+			defaultLocale = string(m[1])
+		}
+
+		return locales, defaultLocale, nil
+	}
+
+	return nil, "", fmt.Errorf("未找到语言配置: %s", strings.Join(paths, ", "))
+}
+
+// 需要翻译的语言：配置中除默认语言以外的全部语言
+func targetLocales(locales []string, defaultLocale string) []string {
+	targets := []string{}
+	for _, locale := range locales {
+		if locale != defaultLocale {
+			targets = append(targets, locale)
+		}
+	}
+	return targets
+}
+
+// 将 messages/en 翻译到所有配置的语言目录（messages/<locale>），跳过默认语言
+// 所有语言的文件任务放入同一个 worker 池并发执行
+func translateAllLocales(sourceDir string, locales []string, defaultLocale string, translator Translator) []LocaleSummary {
+	messagesRoot := filepath.Dir(filepath.Clean(sourceDir))
+	summaries := []LocaleSummary{}
+	localeStats := []*TranslationStats{}
+	jobs := []fileJob{}
+
+	for _, locale := range targetLocales(locales, defaultLocale) {
+		targetDir := filepath.Join(messagesRoot, locale)
+		summary := LocaleSummary{Locale: locale}
+		stats := &TranslationStats{}
+
+		// 不认识的语言直接报错，不再猜测
+		info, err := resolveLocale(locale)
+		if err != nil {
+			summary.Err = err
+			fmt.Printf("❌ 错误 (%s): %v\n", locale, err)
+		} else if summary.Lang = info.Code; !supportsLanguage(translator, summary.Lang) {
+			summary.Err = fmt.Errorf("%s 不支持目标语言 %s", translator.Name(), summary.Lang)
+			fmt.Printf("❌ 错误 (%s): %v\n", locale, summary.Err)
+		} else {
+			localeJobs, err := listFileJobs(sourceDir, targetDir, summary.Lang, stats)
+			if err != nil {
+				summary.Err = err
+				fmt.Printf("❌ 错误 (%s): %v\n", locale, err)
+			}
+			jobs = append(jobs, localeJobs...)
+		}
+
+		summaries = append(summaries, summary)
+		localeStats = append(localeStats, stats)
+	}
+
+	fmt.Printf("📂 共 %d 个语言、%d 个文件任务，并发数: %d\n", len(summaries), len(jobs), workerCount)
+
+	results := runFileJobs(jobs, translator, workerCount)
+
+	// 按语言汇总任务结果
+	for i := range summaries {
+		summary := &summaries[i]
+		stats := localeStats[i]
+		var first, last time.Time
+		for _, result := range results {
+			if result.Job.Stats != stats {
+				continue
+			}
+			if result.Err != nil {
+				summary.Failed++
+			} else {
+				summary.Files++
+			}
+			if first.IsZero() || result.Started.Before(first) {
+				first = result.Started
+			}
+			if result.Finished.After(last) {
+				last = result.Finished
+			}
+		}
+		summary.Elapsed = last.Sub(first)
+		summary.Requests = int(stats.Requests.Load())
+		summary.CacheHits = int(stats.CacheHits.Load())
+		summary.CacheMisses = int(stats.CacheMisses.Load())
+	}
+
+	return summaries
+}
+
+// 打印每个语言的汇总表
+func printLocaleSummaries(out io.Writer, summaries []LocaleSummary) {
+	fmt.Fprintf(out, "\n📋 各语言汇总:\n\n")
+	w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "Locale\tLang\tFiles\tFailed\tInvalid\tSuspect\tTooLong\tRequests\tHits\tMisses\tTime\tStatus")
+	for _, summary := range summaries {
+		invalid := validationFailures.Count(summary.Locale)
+		suspect := untranslatedWarnings.Count(summary.Locale)
+		tooLong := lengthViolations.Count(summary.Locale)
+		status := "✅"
+		if summary.Err != nil {
+			status = "❌ " + summary.Err.Error()
+		} else if summary.Failed > 0 || invalid > 0 || suspect > 0 || tooLong > 0 {
+			status = "⚠️"
+		}
+		fmt.Fprintf(w, "%s\t%s\t%d\t%d\t%d\t%d\t%d\t%d\t%d\t%d\t%.1fs\t%s\n",
+			summary.Locale, summary.Lang, summary.Files, summary.Failed, invalid, suspect, tooLong,
+			summary.Requests, summary.CacheHits, summary.CacheMisses, summary.Elapsed.Seconds(), status)
+	}
+	w.Flush()
+}
+
+// 过期译文报告（单个命名空间文件）
+type StaleReport struct {
+	Locale    string
+	File      string
+	Stale     []string // 原文已变化的键
+	Missing   []string // 目标文件缺少译文的键
+	Untracked []string // 有译文但锁文件没有记录的键
+}
+
+// 检查一个目标目录中所有文件的过期译文
+func checkStaleDirectory(sourceDir, targetDir string) ([]StaleReport, error) {
+	files, err := ioutil.ReadDir(sourceDir)
+	if err != nil {
+		return nil, fmt.Errorf("读取目录失败: %v", err)
+	}
+
+	reports := []StaleReport{}
+	for _, file := range files {
+		if file.IsDir() || !strings.HasSuffix(file.Name(), ".json") {
+			continue
+		}
+
+		data, err := ioutil.ReadFile(filepath.Join(sourceDir, file.Name()))
+		if err != nil {
+			return nil, fmt.Errorf("读取文件失败: %v", err)
+		}
+		sourceData, err := decodeOrderedJSON(data)
+		if err != nil {
+			return nil, fmt.Errorf("解析 %s 失败: %v", file.Name(), err)
+		}
+
+		existing, err := loadExistingTranslations(filepath.Join(targetDir, file.Name()))
+		if err != nil {
+			return nil, err
+		}
+		lock, err := loadTranslationLock(targetDir, file.Name())
+		if err != nil {
+			return nil, err
+		}
+
+		texts := NewTextCollection()
+		collectTexts(sourceData, "", texts)
+
+		report := StaleReport{Locale: filepath.Base(targetDir), File: file.Name()}
+		for _, text := range texts.Order {
+			for _, path := range texts.KeyPaths[text] {
+				if translated, ok := existing[path]; !ok || translated == "" {
+					report.Missing = append(report.Missing, path)
+				} else if isStale(lock, path, text) {
+					report.Stale = append(report.Stale, path)
+				} else if _, ok := lock.Hashes[path]; !ok {
+					report.Untracked = append(report.Untracked, path)
+				}
+			}
+		}
+
+		if len(report.Stale)+len(report.Missing)+len(report.Untracked) > 0 {
+			reports = append(reports, report)
+		}
+	}
+	return reports, nil
+}
+
+// stale 子命令：列出英文原文在翻译之后发生变化的键
+func runStaleCommand(args []string) int {
+	fs := flag.NewFlagSet("stale", flag.ExitOnError)
+	sourceDir := fs.String("source", "./messages/en", "源文件目录")
+	targetDir := fs.String("target", "./messages/it", "目标文件目录")
+	lockDir := fs.String("lock-dir", defaultLockDir, "翻译锁文件目录")
+	fs.Parse(args)
+
+	lockRootDir = *lockDir
+
+	reports, err := checkStaleDirectory(*sourceDir, *targetDir)
+	if err != nil {
+		fmt.Printf("❌ 错误: %v\n", err)
+		return 1
+	}
+
+	staleCount, missingCount, untrackedCount := 0, 0, 0
+	for _, report := range reports {
+		fmt.Printf("\n📄 %s/%s\n", report.Locale, report.File)
+		for _, path := range report.Stale {
+			fmt.Printf("  ✏️  原文已变化: %s\n", path)
+		}
+		for _, path := range report.Missing {
+			fmt.Printf("  ❓ 缺少译文:   %s\n", path)
+		}
+		for _, path := range report.Untracked {
+			fmt.Printf("  ·  未记录哈希: %s\n", path)
+		}
+		staleCount += len(report.Stale)
+		missingCount += len(report.Missing)
+		untrackedCount += len(report.Untracked)
+	}
+
+	fmt.Printf("\n📊 原文已变化: %d | 缺少译文: %d | 未记录哈希: %d\n", staleCount, missingCount, untrackedCount)
+	if staleCount+missingCount > 0 {
+		fmt.Printf("💡 运行 -incremental 模式即可只翻译这些键\n")
+	}
+	return 0
+}
+
+// 单个语言命名空间文件的检查结果（与 messages/en 对比）
+type CheckReport struct {
+	Locale         string         `json:"locale"`
+	File           string         `json:"file"`
+	MissingFile    bool           `json:"missingFile,omitempty"`    // 目标语言缺少整个文件
+	ExtraFile      bool           `json:"extraFile,omitempty"`      // 英文中不存在的文件
+	Missing        []string       `json:"missing,omitempty"`        // 缺少的键
+	Extra          []string       `json:"extra,omitempty"`          // 英文中不存在的多余键
+	TypeMismatches []TypeMismatch `json:"typeMismatches,omitempty"` // 类型不一致的键
+	Empty          []string       `json:"empty,omitempty"`          // 译文为空的键
+	Error          string         `json:"error,omitempty"`          // 文件无法读取或解析
+}
+
+// 类型不一致的键（如英文为数组，译文为字符串）
+type TypeMismatch struct {
+	Key      string `json:"key"`
+	Expected string `json:"expected"`
+	Actual   string `json:"actual"`
+}
+
+// 问题总数
+func (r CheckReport) issueCount() int {
+	count := len(r.Missing) + len(r.Extra) + len(r.TypeMismatches) + len(r.Empty)
+	if r.Error != "" || r.ExtraFile {
+		count++
+	}
+	return count
+}
+
+// JSON 值的类型名称
+func jsonTypeName(value interface{}) string {
+	switch value.(type) {
+	case *OrderedMap:
+		return "object"
+	case []interface{}:
+		return "array"
+	case string:
+		return "string"
+	case json.Number:
+		return "number"
+	case bool:
+		return "boolean"
+	default:
+		return "null"
+	}
+}
+
+// 收集值下面所有叶子节点的键路径（对象和数组展开到最底层）
+func leafKeyPaths(value interface{}, path string, out *[]string) {
+	switch v := value.(type) {
+	case *OrderedMap:
+		for _, key := range v.Keys {
+			leafKeyPaths(v.Values[key], joinKeyPath(path, key), out)
+		}
+	case []interface{}:
+		for i, item := range v {
+			leafKeyPaths(item, joinKeyPath(path, strconv.Itoa(i)), out)
+		}
+	default:
+		*out = append(*out, path)
+	}
+}
+
+// 递归比较英文和目标语言的结构
+func compareMessages(source, target interface{}, path string, report *CheckReport) {
+	if expected, actual := jsonTypeName(source), jsonTypeName(target); expected != actual {
+		report.TypeMismatches = append(report.TypeMismatches, TypeMismatch{Key: path, Expected: expected, Actual: actual})
+		return
+	}
+
+	switch s := source.(type) {
+	case *OrderedMap:
+		t := target.(*OrderedMap)
+		for _, key := range s.Keys {
+			child := joinKeyPath(path, key)
+			if value, ok := t.Get(key); ok {
+				compareMessages(s.Values[key], value, child, report)
+			} else {
+				leafKeyPaths(s.Values[key], child, &report.Missing)
+			}
+		}
+		for _, key := range t.Keys {
+			if _, ok := s.Get(key); !ok {
+				leafKeyPaths(t.Values[key], joinKeyPath(path, key), &report.Extra)
+			}
+		}
+	case []interface{}:
+		t := target.([]interface{})
+		for i, item := range s {
+			child := joinKeyPath(path, strconv.Itoa(i))
+			if i < len(t) {
+				compareMessages(item, t[i], child, report)
+			} else {
+				leafKeyPaths(item, child, &report.Missing)
+			}
+		}
+		for i := len(s); i < len(t); i++ {
+			leafKeyPaths(t[i], joinKeyPath(path, strconv.Itoa(i)), &report.Extra)
+		}
+	case string:
+		if strings.TrimSpace(target.(string)) == "" && strings.TrimSpace(s) != "" {
+			report.Empty = append(report.Empty, path)
+		}
+	}
+}
+
+// 读取并解析 JSON 文件（保持键顺序）
+func readOrderedJSONFile(path string) (interface{}, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return decodeOrderedJSON(data)
+}
+
+// 检查一个语言目录的所有命名空间文件，只返回有问题的文件
+func checkLocaleDirectory(sourceDir, targetDir string) ([]CheckReport, error) {
+	files, err := ioutil.ReadDir(sourceDir)
+	if err != nil {
+		return nil, fmt.Errorf("读取目录失败: %v", err)
+	}
+
+	locale := filepath.Base(targetDir)
+	reports := []CheckReport{}
+	sourceFiles := make(map[string]bool)
+	for _, file := range files {
+		if file.IsDir() || !strings.HasSuffix(file.Name(), ".json") {
+			continue
+		}
+		sourceFiles[file.Name()] = true
+
+		sourceData, err := readOrderedJSONFile(filepath.Join(sourceDir, file.Name()))
+		if err != nil {
+			return nil, fmt.Errorf("解析 %s 失败: %v", file.Name(), err)
+		}
+
+		report := CheckReport{Locale: locale, File: file.Name()}
+		targetData, err := readOrderedJSONFile(filepath.Join(targetDir, file.Name()))
+		switch {
+		case os.IsNotExist(err):
+			report.MissingFile = true
+			leafKeyPaths(sourceData, "", &report.Missing)
+		case err != nil:
+			report.Error = err.Error()
+		default:
+			compareMessages(sourceData, targetData, "", &report)
+		}
+
+		if report.issueCount() > 0 {
+			reports = append(reports, report)
+		}
+	}
+
+	// 目标目录中多余的命名空间文件
+	targetFiles, err := ioutil.ReadDir(targetDir)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("读取目录失败: %v", err)
+	}
+	for _, file := range targetFiles {
+		if !file.IsDir() && strings.HasSuffix(file.Name(), ".json") && !sourceFiles[file.Name()] {
+			reports = append(reports, CheckReport{Locale: locale, File: file.Name(), ExtraFile: true})
+		}
+	}
+	return reports, nil
+}
+
+// 检查结果汇总（JSON 输出格式）
+type CheckSummary struct {
+	Reports        []CheckReport `json:"reports"`
+	Missing        int           `json:"missing"`
+	Extra          int           `json:"extra"`
+	TypeMismatches int           `json:"typeMismatches"`
+	Empty          int           `json:"empty"`
+	Errors         int           `json:"errors"`
+}
+
+// 统计所有报告
+func summarizeCheckReports(reports []CheckReport) CheckSummary {
+	summary := CheckSummary{Reports: reports}
+	for _, report := range reports {
+		summary.Missing += len(report.Missing)
+		summary.Extra += len(report.Extra)
+		summary.TypeMismatches += len(report.TypeMismatches)
+		summary.Empty += len(report.Empty)
+		if report.Error != "" || report.ExtraFile {
+			summary.Errors++
+		}
+	}
+	return summary
+}
+
+// 文本格式（终端输出）
+func formatCheckText(summary CheckSummary) string {
+	var sb strings.Builder
+	for _, report := range summary.Reports {
+		fmt.Fprintf(&sb, "\n📄 %s/%s\n", report.Locale, report.File)
+		if report.MissingFile {
+			fmt.Fprintf(&sb, "  ❌ 缺少文件\n")
+		}
+		if report.ExtraFile {
+			fmt.Fprintf(&sb, "  ❌ 英文中不存在该文件\n")
+		}
+		if report.Error != "" {
+			fmt.Fprintf(&sb, "  ❌ 无法解析: %s\n", report.Error)
+		}
+		for _, path := range report.Missing {
+			fmt.Fprintf(&sb, "  ❓ 缺少:       %s\n", path)
+		}
+		for _, path := range report.Extra {
+			fmt.Fprintf(&sb, "  ➕ 多余:       %s\n", path)
+		}
+		for _, mismatch := range report.TypeMismatches {
+			fmt.Fprintf(&sb, "  🔀 类型不一致: %s (%s → %s)\n", mismatch.Key, mismatch.Expected, mismatch.Actual)
+		}
+		for _, path := range report.Empty {
+			fmt.Fprintf(&sb, "  ⬜ 空译文:     %s\n", path)
+		}
+	}
+	fmt.Fprintf(&sb, "\n📊 缺少: %d | 多余: %d | 类型不一致: %d | 空译文: %d | 文件错误: %d\n",
+		summary.Missing, summary.Extra, summary.TypeMismatches, summary.Empty, summary.Errors)
+	return sb.String()
+}
+
+// Markdown 格式（CI 评论、任务摘要）
+func formatCheckMarkdown(summary CheckSummary, locales []string) string {
+	var sb strings.Builder
+	sb.WriteString("# Translation check\n\n")
+	sb.WriteString("| Locale | Missing | Extra | Type mismatch | Empty | File errors |\n")
+	sb.WriteString("| --- | --- | --- | --- | --- | --- |\n")
+	for _, locale := range locales {
+		var missing, extra, mismatches, empty, errors int
+		for _, report := range summary.Reports {
+			if report.Locale != locale {
+				continue
+			}
+			missing += len(report.Missing)
+			extra += len(report.Extra)
+			mismatches += len(report.TypeMismatches)
+			empty += len(report.Empty)
+			if report.Error != "" || report.ExtraFile {
+				errors++
+			}
+		}
+		fmt.Fprintf(&sb, "| %s | %d | %d | %d | %d | %d |\n", locale, missing, extra, mismatches, empty, errors)
+	}
+
+	currentLocale := ""
+	for _, report := range summary.Reports {
+		if report.Locale != currentLocale {
+			currentLocale = report.Locale
+			fmt.Fprintf(&sb, "\n## %s\n", currentLocale)
+		}
+		fmt.Fprintf(&sb, "\n### %s\n\n", report.File)
+		if report.MissingFile {
+			sb.WriteString("- File is missing\n")
+		}
+		if report.ExtraFile {
+			sb.WriteString("- File does not exist in the source locale\n")
+		}
+		if report.Error != "" {
+			fmt.Fprintf(&sb, "- Parse error: %s\n", report.Error)
+		}
+		for _, path := range report.Missing {
+			fmt.Fprintf(&sb, "- Missing: `%s`\n", path)
+		}
+		for _, path := range report.Extra {
+			fmt.Fprintf(&sb, "- Extra: `%s`\n", path)
+		}
+		for _, mismatch := range report.TypeMismatches {
+			fmt.Fprintf(&sb, "- Type mismatch: `%s` (%s → %s)\n", mismatch.Key, mismatch.Expected, mismatch.Actual)
+		}
+		for _, path := range report.Empty {
+			fmt.Fprintf(&sb, "- Empty: `%s`\n", path)
+		}
+	}
+	return sb.String()
+}
+
+// 解析逗号分隔的语言列表
+func splitLocaleList(list string) []string {
+	var locales []string
+	for _, locale := range strings.Split(list, ",") {
+		if locale = strings.TrimSpace(locale); locale != "" {
+			locales = append(locales, locale)
+		}
+	}
+	return locales
+}
+
+// check 子命令：对比 messages/en，报告各语言缺少、多余、类型不一致和为空的键，有问题时返回非零退出码
+func runCheckCommand(args []string) int {
+	fs := flag.NewFlagSet("check", flag.ExitOnError)
+	sourceDir := fs.String("source", "./messages/en", "源文件目录")
+	localeList := fs.String("locales", "", "要检查的语言，逗号分隔 (默认读取 config/locales.js 中除默认语言外的全部语言)")
+	format := fs.String("format", "text", "输出格式: text、json 或 markdown")
+	output := fs.String("output", "", "报告输出文件 (默认输出到终端)")
+	fs.Parse(args)
+
+	locales := splitLocaleList(*localeList)
+	if len(locales) == 0 {
+		configured, defaultLocale, err := loadLocaleConfig(localeConfigPaths)
+		if err != nil {
+			fmt.Printf("❌ 错误: %v\n", err)
+			return 1
+		}
+		for _, locale := range configured {
+			if locale != defaultLocale {
+				locales = append(locales, locale)
+			}
+		}
+	}
+
+	messagesDir := filepath.Dir(filepath.Clean(*sourceDir))
+	reports := []CheckReport{}
+	for _, locale := range locales {
+		localeReports, err := checkLocaleDirectory(*sourceDir, filepath.Join(messagesDir, locale))
+		if err != nil {
+			fmt.Printf("❌ 错误: %v\n", err)
+			return 1
+		}
+		reports = append(reports, localeReports...)
+	}
+	summary := summarizeCheckReports(reports)
+
+	var content string
+	switch *format {
+	case "text":
+		content = formatCheckText(summary)
+	case "json":
+		data, _ := json.MarshalIndent(summary, "", "  ")
+		content = string(data) + "\n"
+	case "markdown", "md":
+		content = formatCheckMarkdown(summary, locales)
+	default:
+		fmt.Printf("❌ 错误: 不支持的输出格式: %s (可选: text, json, markdown)\n", *format)
+		return 1
+	}
+
+	if *output != "" {
+		if err := ioutil.WriteFile(*output, []byte(content), 0644); err != nil {
+			fmt.Printf("❌ 错误: 写入报告失败: %v\n", err)
+			return 1
+		}
+		fmt.Printf("📝 报告已保存到: %s\n", *output)
+	} else {
+		fmt.Print(content)
+	}
+
+	for _, report := range reports {
+		if report.issueCount() > 0 {
+			return 1
+		}
+	}
+	return 0
+}
+
+// 将 messages/<locale>/*.json 合并为 messages/<locale>.json（文件名作为命名空间），返回合并的文件数
+// 所有命名空间文件都能解析且没有冲突时才写入，避免生成不完整的合并文件
+func mergeLocaleMessages(messagesDir, locale string) (int, error) {
+	localeDir := filepath.Join(messagesDir, locale)
+	files, err := ioutil.ReadDir(localeDir)
+	if err != nil {
+		return 0, fmt.Errorf("读取目录失败: %v", err)
+	}
+
+	merged := NewOrderedMap()
+	namespaces := make(map[string]string)
+	for _, file := range files {
+		if file.IsDir() || !strings.HasSuffix(file.Name(), ".json") {
+			continue
+		}
+
+		// 命名空间按小写比较：common.json 与 Common.json 在不区分大小写的文件系统上会互相覆盖
+		namespace := strings.TrimSuffix(file.Name(), ".json")
+		if namespace == "" {
+			return 0, fmt.Errorf("无效的命名空间文件: %s", file.Name())
+		}
+		if previous, ok := namespaces[strings.ToLower(namespace)]; ok {
+			return 0, fmt.Errorf("命名空间冲突: %s 与 %s", previous, file.Name())
+		}
+		namespaces[strings.ToLower(namespace)] = file.Name()
+
+		data, err := readOrderedJSONFile(filepath.Join(localeDir, file.Name()))
+		if err != nil {
+			return 0, fmt.Errorf("解析 %s 失败: %v", file.Name(), err)
+		}
+		if _, ok := data.(*OrderedMap); !ok {
+			return 0, fmt.Errorf("%s 的顶层必须是对象", file.Name())
+		}
+		merged.Set(namespace, data)
+	}
+
+	output, err := encodeOrderedJSON(merged, defaultJSONFormat)
+	if err != nil {
+		return 0, fmt.Errorf("生成 JSON 失败: %v", err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(messagesDir, locale+".json"), output, 0644); err != nil {
+		return 0, fmt.Errorf("写入文件失败: %v", err)
+	}
+	return len(merged.Keys), nil
+}
+
+// 合并多个语言，返回失败的语言数
+func mergeMessages(messagesDir string, locales []string) int {
+	fmt.Printf("📦 开始合并翻译文件...\n")
+	failed := 0
+	for _, locale := range locales {
+		count, err := mergeLocaleMessages(messagesDir, locale)
+		if err != nil {
+			fmt.Printf("  ❌ %s: %v\n", locale, err)
+			failed++
+			continue
+		}
+		fmt.Printf("  ✅ 已合并 %s 的翻译文件 (%d 个文件)\n", locale, count)
+	}
+	return failed
+}
+
+// merge 子命令：生成 next-intl 使用的 messages/<locale>.json
+func runMergeCommand(args []string) int {
+	fs := flag.NewFlagSet("merge", flag.ExitOnError)
+	messagesDir := fs.String("messages", "./messages", "翻译文件根目录 (包含各语言子目录)")
+	localeList := fs.String("locales", "", "要合并的语言，逗号分隔 (默认读取 config/locales.js 中的全部语言)")
+	fs.Parse(args)
+
+	locales := splitLocaleList(*localeList)
+	if len(locales) == 0 {
+		configured, _, err := loadLocaleConfig(localeConfigPaths)
+		if err != nil {
+			fmt.Printf("❌ 错误: %v\n", err)
+			return 1
+		}
+		locales = configured
+	}
+
+	if failed := mergeMessages(*messagesDir, locales); failed > 0 {
+		fmt.Printf("❌ %d 个语言合并失败\n", failed)
+		return 1
+	}
+	return 0
+}
+
+// 伪本地化选项
+type PseudoOptions struct {
+	Expansion int  // 加长比例（百分比），模拟德语等较长语言的译文长度
+	Brackets  bool // 两端加 [ ]，被截断或被拼接的文本一眼可见
+	RTL       bool // 镜像变体：用双向控制字符从右向左显示，不加重音
+}
+
+// 伪本地化使用的重音字母（大小写各 26 个，与 A-Z、a-z 一一对应）
+const pseudoAccentLetters = "ÅƁÇĐÉƑĜĤÎĴĶĻṀÑÖÞǪŔŠŢÛṼŴẊÝŽáƀçðéƒĝĥîĵķļṁñöþǫŕšţûṽŵẋýž"
+
+var pseudoAccents = buildPseudoAccents()
+
+func buildPseudoAccents() map[rune]rune {
+	accents := make(map[rune]rune)
+	letters := []rune(pseudoAccentLetters)
+	for i := 0; i < 26; i++ {
+		accents['A'+rune(i)] = letters[i]
+		accents['a'+rune(i)] = letters[26+i]
+	}
+	return accents
+}
+
+// 生成一条伪本地化文本
+// 复数/选择消息只处理各选项中的文字，占位符、富文本标签和专有名词原样保留
+func pseudoLocalize(text string, opts PseudoOptions) string {
+	var result string
+	nodes, err := parseICUMessage(text)
+	if strings.Contains(text, ",") && err == nil && hasICUSelector(nodes) {
+		result = formatICUMessage(pseudoICUNodes(nodes, opts), false)
+	} else {
+		result = pseudoSegment(text, opts)
+	}
+	if opts.Brackets {
+		result = "[" + result + "]"
+	}
+	return result
+}
+
+// 递归处理 ICU 语法树中的文字
+func pseudoICUNodes(nodes []icuNode, opts PseudoOptions) []icuNode {
+	result := make([]icuNode, len(nodes))
+	for i, node := range nodes {
+		switch n := node.(type) {
+		case *icuText:
+			result[i] = &icuText{Value: pseudoSegment(n.Value, opts)}
+		case *icuSelector:
+			selector := *n
+			selector.Options = make([]icuOption, len(n.Options))
+			for j, option := range n.Options {
+				selector.Options[j] = icuOption{Selector: option.Selector, Message: pseudoICUNodes(option.Message, opts)}
+			}
+			result[i] = &selector
+		default:
+			result[i] = node
+		}
+	}
+	return result
+}
+
+// 保护占位符后处理普通文字，并按文字中的字母数加长
+func pseudoSegment(text string, opts PseudoOptions) string {
+	markup := newSentinelMarkup(text)
+	gen := NewPlaceholderGenerator(markup)
+	protected, _ := protectAllContentWithGenerator(text, gen)
+
+	var sb strings.Builder
+	letters, last := 0, 0
+	for _, loc := range markup.pattern.FindAllStringIndex(protected, -1) {
+		letters += pseudoRun(&sb, protected[last:loc[0]], opts)
+		sb.WriteString(protected[loc[0]:loc[1]])
+		last = loc[1]
+	}
+	letters += pseudoRun(&sb, protected[last:], opts)
+	if padding := (letters*opts.Expansion + 99) / 100; padding > 0 {
+		sb.WriteString(" " + strings.Repeat("~", padding))
+	}
+
+	restored, _ := restoreProtectedContent(sb.String(), gen)
+	return restored
+}
+
+// 处理一段不含受保护内容的文字，返回其中的字母数
+func pseudoRun(sb *strings.Builder, text string, opts PseudoOptions) int {
+	letters := 0
+	var run strings.Builder
+	for _, r := range text {
+		if unicode.IsLetter(r) {
+			letters++
+		}
+		if accented, ok := pseudoAccents[r]; ok && !opts.RTL {
+			r = accented
+		}
+		run.WriteRune(r)
+	}
+	if opts.RTL && letters > 0 {
+		// U+202E 从右向左覆盖，U+202C 结束覆盖
+		sb.WriteString("\u202e" + run.String() + "\u202c")
+	} else {
+		sb.WriteString(run.String())
+	}
+	return letters
+}
+
+// 为源目录中的每个文件生成伪本地化版本，返回写入的文件数
+func writePseudoLocale(sourceDir, targetDir string, opts PseudoOptions) (int, error) {
+	files, err := filepath.Glob(filepath.Join(sourceDir, "*.json"))
+	if err != nil {
+		return 0, err
+	}
+	if len(files) == 0 {
+		return 0, fmt.Errorf("%s 中没有 JSON 文件", sourceDir)
+	}
+	if err := os.MkdirAll(targetDir, 0755); err != nil {
+		return 0, fmt.Errorf("创建目录失败: %v", err)
+	}
+
+	for _, file := range files {
+		raw, err := ioutil.ReadFile(file)
+		if err != nil {
+			return 0, fmt.Errorf("读取文件失败: %v", err)
+		}
+		data, err := decodeOrderedJSON(raw)
+		if err != nil {
+			return 0, fmt.Errorf("解析 %s 失败: %v", filepath.Base(file), err)
+		}
+
+		texts := NewTextCollection()
+		collectTexts(data, "", texts)
+		translations := make(map[string]string, len(texts.Order))
+		for _, text := range texts.Order {
+			translations[text] = pseudoLocalize(text, opts)
+		}
+
+		output, err := encodeOrderedJSON(translateJSON(data, translations), detectJSONFormat(raw))
+		if err != nil {
+			return 0, fmt.Errorf("生成 JSON 失败: %v", err)
+		}
+		if err := ioutil.WriteFile(filepath.Join(targetDir, filepath.Base(file)), output, 0644); err != nil {
+			return 0, fmt.Errorf("写入文件失败: %v", err)
+		}
+	}
+	return len(files), nil
+}
+
+// pseudo 子命令：从英文生成伪本地化语言，用于发现硬编码文本和截断，不调用翻译服务
+func runPseudoCommand(args []string) int {
+	fs := flag.NewFlagSet("pseudo", flag.ExitOnError)
+	sourceDir := fs.String("source", "./messages/en", "源文件目录")
+	locale := fs.String("locale", "en-XA", "伪本地化语言名称 (写入 messages/<locale>)")
+	expansion := fs.Int("expansion", 35, "文本加长比例 (百分比，建议 30-40)")
+	brackets := fs.Bool("brackets", true, "在每条文本两端加 [ ]")
+	rtl := fs.Bool("rtl", false, "同时生成镜像的从右向左变体")
+	rtlLocale := fs.String("rtl-locale", "ar-XB", "从右向左变体的语言名称")
+	fs.Parse(args)
+
+	if *expansion < 0 || *expansion > 200 {
+		fmt.Printf("❌ 错误: -expansion 必须在 0-200 之间\n")
+		return 1
+	}
+	if err := loadProperNounsConfig("./config/proper-nouns.json"); err != nil {
+		fmt.Printf("⚠️  警告: 加载专有名词配置失败: %v\n", err)
+	}
+
+	messagesDir := filepath.Dir(filepath.Clean(*sourceDir))
+	variants := map[string]PseudoOptions{*locale: {Expansion: *expansion, Brackets: *brackets}}
+	order := []string{*locale}
+	if *rtl {
+		variants[*rtlLocale] = PseudoOptions{Expansion: *expansion, Brackets: *brackets, RTL: true}
+		order = append(order, *rtlLocale)
+	}
+
+	for _, name := range order {
+		count, err := writePseudoLocale(*sourceDir, filepath.Join(messagesDir, name), variants[name])
+		if err != nil {
+			fmt.Printf("❌ %s: %v\n", name, err)
+			return 1
+		}
+		fmt.Printf("✅ 已生成 %s 的伪本地化文件 (%d 个文件)\n", name, count)
+	}
+	return 0
+}
+
+// 导出给人工翻译的一条文本
+type ExportEntry struct {
+	File   string // 命名空间文件名，如 pricing.json
+	Key    string // JSON 键路径
+	Source string // 英文原文
+	Target string // 已有译文（没有时为空）
+	Stale  bool   // 已有译文，但英文原文在翻译后变化
+}
+
+// 收集源目录中所有需要翻译的文本及目标语言的已有译文（按文件名和源文件中的键顺序）
+// pendingOnly 为 true 时只收集缺少译文或原文已变化的键
+func collectExportEntries(sourceDir, targetDir string, pendingOnly bool) ([]ExportEntry, error) {
+	files, err := ioutil.ReadDir(sourceDir)
+	if err != nil {
+		return nil, fmt.Errorf("读取目录失败: %v", err)
+	}
+
+	entries := []ExportEntry{}
+	for _, file := range files {
+		if file.IsDir() || !strings.HasSuffix(file.Name(), ".json") {
+			continue
+		}
+		sourceData, err := readOrderedJSONFile(filepath.Join(sourceDir, file.Name()))
+		if err != nil {
+			return nil, fmt.Errorf("解析 %s 失败: %v", file.Name(), err)
+		}
+		existing, err := loadExistingTranslations(filepath.Join(targetDir, file.Name()))
+		if err != nil {
+			return nil, err
+		}
+		lock, err := loadTranslationLock(targetDir, file.Name())
+		if err != nil {
+			return nil, err
+		}
+
+		paths := []string{}
+		leafKeyPaths(sourceData, "", &paths)
+		sourceTexts := make(map[string]string)
+		flattenStrings(sourceData, "", sourceTexts)
+		for _, path := range paths {
+			text, ok := sourceTexts[path]
+			if !ok || text == "" || isPlaceholder(text) {
+				continue
+			}
+			entry := ExportEntry{File: file.Name(), Key: path, Source: text, Target: existing[path]}
+			entry.Stale = entry.Target != "" && isStale(lock, path, text)
+			if pendingOnly && entry.Target != "" && !entry.Stale {
+				continue
+			}
+			entries = append(entries, entry)
+		}
+	}
+	return entries, nil
+}
+
+// 从外部文件导入的一条译文
+type ImportedTranslation struct {
+	File   string // 命名空间文件名
+	Key    string // JSON 键路径
+	Source string // 导出时的英文原文（为空时不检查）
+	Target string
+}
+
+// 把导入的译文写回 messages/<locale>/*.json，返回写入的键数和校验失败的键
+// 每条译文与当前英文原文比对：原文已变化、键不存在或占位符不一致的译文不写入
+// 目标文件中其他键的值和顺序保持不变，缺少的键按源文件中的位置插入
+func importTranslations(sourceDir, targetDir string, items []ImportedTranslation) (int, []ValidationFailure, error) {
+	locale := filepath.Base(targetDir)
+	byFile := make(map[string][]ImportedTranslation)
+	fileNames := []string{}
+	for _, item := range items {
+		if _, ok := byFile[item.File]; !ok {
+			fileNames = append(fileNames, item.File)
+		}
+		byFile[item.File] = append(byFile[item.File], item)
+	}
+	sort.Strings(fileNames)
+
+	applied := 0
+	failures := []ValidationFailure{}
+	for _, fileName := range fileNames {
+		fail := func(key, reason string) {
+			failures = append(failures, ValidationFailure{Locale: locale, File: fileName, Key: key, Reason: reason})
+		}
+		if fileName != filepath.Base(fileName) || !strings.HasSuffix(fileName, ".json") {
+			fail("", "无效的文件名")
+			continue
+		}
+
+		sourceRaw, err := ioutil.ReadFile(filepath.Join(sourceDir, fileName))
+		if err != nil {
+			fail("", "源目录中没有该文件")
+			continue
+		}
+		sourceData, err := decodeOrderedJSON(sourceRaw)
+		if err != nil {
+			return applied, failures, fmt.Errorf("解析 %s 失败: %v", fileName, err)
+		}
+		sourceTexts := make(map[string]string)
+		flattenStrings(sourceData, "", sourceTexts)
+
+		imported := make(map[string]string)
+		for _, item := range byFile[fileName] {
+			current, ok := sourceTexts[item.Key]
+			if !ok {
+				fail(item.Key, "源文件中没有该键")
+			} else if item.Source != "" && item.Source != current {
+				fail(item.Key, "英文原文在导出后已变化")
+			} else if err := validateTranslation(current, item.Target); err != nil {
+				fail(item.Key, err.Error())
+			} else if err := validateICUSyntax(current, item.Target); err != nil {
+				fail(item.Key, err.Error())
+			} else {
+				imported[item.Key] = item.Target
+			}
+		}
+		if len(imported) == 0 {
+			continue
+		}
+
+		// 在已有目标文件上修改；目标文件不存在时按源文件格式新建
+		targetFile := filepath.Join(targetDir, fileName)
+		var targetData interface{}
+		format := detectJSONFormat(sourceRaw)
+		if targetRaw, err := ioutil.ReadFile(targetFile); err == nil {
+			if targetData, err = decodeOrderedJSON(targetRaw); err != nil {
+				return applied, failures, fmt.Errorf("解析目标文件 %s 失败: %v", fileName, err)
+			}
+			format = detectJSONFormat(targetRaw)
+		} else if !os.IsNotExist(err) {
+			return applied, failures, fmt.Errorf("读取目标文件失败: %v", err)
+		}
+
+		output, err := encodeOrderedJSON(mergeImported(sourceData, targetData, "", imported), format)
+		if err != nil {
+			return applied, failures, fmt.Errorf("生成 JSON 失败: %v", err)
+		}
+		if err := os.MkdirAll(targetDir, 0755); err != nil {
+			return applied, failures, fmt.Errorf("创建目录失败: %v", err)
+		}
+		if err := ioutil.WriteFile(targetFile, output, 0644); err != nil {
+			return applied, failures, fmt.Errorf("写入文件失败: %v", err)
+		}
+
+		// 导入的译文对应当前原文，记录哈希（增量模式不会再翻译这些键）
+		lock, err := loadTranslationLock(targetDir, fileName)
+		if err != nil {
+			return applied, failures, err
+		}
+		for path := range imported {
+			lock.Hashes[path] = sourceHash(sourceTexts[path])
+		}
+		if err := saveTranslationLock(targetDir, fileName, lock); err != nil {
+			fmt.Printf("⚠️  锁文件保存失败: %v\n", err)
+		}
+		applied += len(imported)
+	}
+	return applied, failures, nil
+}
+
+// 原文是 ICU plural/select 消息时，译文也必须能解析（人工编辑可能破坏选项的括号）
+func validateICUSyntax(source, translated string) error {
+	nodes, err := parseICUMessage(source)
+	if err != nil || !hasICUSelector(nodes) {
+		return nil
+	}
+	if _, err := parseICUMessage(translated); err != nil {
+		return fmt.Errorf("ICU 语法错误: %v", err)
+	}
+	return nil
+}
+
+// 按源文件结构把导入的译文合并进目标数据
+// 目标中已有的键原地替换；缺少的键插入到源文件中前一个键之后
+// 没有导入内容的分支原样返回目标值（包括类型与源文件不一致的值），缺失时为 nil
+func mergeImported(source, target interface{}, path string, imported map[string]string) interface{} {
+	if !hasImportedUnder(imported, path) {
+		return target
+	}
+	switch s := source.(type) {
+	case *OrderedMap:
+		t, ok := target.(*OrderedMap)
+		if !ok {
+			t = NewOrderedMap()
+			t.copyLayout(s)
+		}
+		insertAt := 0
+		for _, key := range s.Keys {
+			existing, has := t.Get(key)
+			merged := mergeImported(s.Values[key], existing, joinKeyPath(path, key), imported)
+			if has {
+				t.Values[key] = merged
+				insertAt = indexOfKey(t.Keys, key) + 1
+			} else if merged != nil {
+				t.Keys = append(t.Keys[:insertAt], append([]string{key}, t.Keys[insertAt:]...)...)
+				t.Values[key] = merged
+				insertAt++
+			}
+		}
+		if len(t.Keys) == 0 && !ok {
+			return nil
+		}
+		return t
+	case []interface{}:
+		t, ok := target.([]interface{})
+		for i, item := range s {
+			var existing interface{}
+			if i < len(t) {
+				existing = t[i]
+			}
+			merged := mergeImported(item, existing, joinKeyPath(path, strconv.Itoa(i)), imported)
+			if i < len(t) {
+				t[i] = merged
+			} else if merged != nil {
+				// 数组中间缺少的元素用英文原文补齐
+				for len(t) < i {
+					t = append(t, s[len(t)])
+				}
+				t = append(t, merged)
+			}
+		}
+		if len(t) == 0 && !ok {
+			return nil
+		}
+		return t
+	case string:
+		if translated, ok := imported[path]; ok {
+			return translated
 		}
-		lastRequestTime = time.Now()
+		return target
+	default:
+		return target
+	}
+}
 
-		// 调用单批翻译函数
-		batchResults, err := translateSingleBatch(apiKey, batchTexts, targetLang)
-		if err != nil {
-			return nil, fmt.Errorf("翻译批次失败: %v", err)
+// 检查键路径本身或其下级是否有导入的译文
+func hasImportedUnder(imported map[string]string, path string) bool {
+	if path == "" {
+		return len(imported) > 0
+	}
+	for key := range imported {
+		if key == path || strings.HasPrefix(key, path+".") {
+			return true
 		}
+	}
+	return false
+}
 
-		// 累加结果
-		for _, text := range batchResults {
-			allTranslations = append(allTranslations, TranslationItem{Text: text})
+// 查找键在有序键列表中的位置
+func indexOfKey(keys []string, key string) int {
+	for i, k := range keys {
+		if k == key {
+			return i
 		}
-
-		fmt.Printf("  ✓ 已处理批次: %d/%d\n", batchEnd, len(toTranslate))
 	}
+	return -1
+}
 
-	// 构建兼容格式的结果
-	type CompatibleResponse struct {
-		Translations []TranslationItem `json:"translations"`
+// 打印导入结果，有失败的键时返回 1
+func reportImport(applied int, failures []ValidationFailure) int {
+	for _, failure := range failures {
+		fmt.Printf("  ❌ %s %s: %s\n", failure.File, failure.Key, failure.Reason)
 	}
-	result := CompatibleResponse{
-		Translations: allTranslations,
+	fmt.Printf("📊 已导入: %d | 未导入: %d\n", applied, len(failures))
+	if len(failures) > 0 {
+		return 1
 	}
+	return 0
+}
 
-	// 保存翻译结果
-	for i, translation := range result.Translations {
-		if i < len(toTranslate) {
-			// 获取原始文本和翻译后的文本
-			originalText := toTranslateOriginals[i]
-			translatedText := translation.Text
-			protectedMap := toTranslateProtectedMaps[i]
+// XLIFF 行内标记：占位符和富文本标签写为 <ph>（原始内容放在 originalData），专有名词写为 translate="no" 的 <mrk>
+// ICU plural/select 消息只把选择器语法、# 和参数写为 <ph>，各选项中的文字照常翻译
+// 同一单元的原文和译文共用 originalData，相同内容使用相同的 dataRef
+type xliffMarkup struct {
+	data        []string
+	dataIDs     map[string]string
+	occurrences map[string]int
+	marks       int
+}
 
-			// 调试日志：显示翻译前后的状态（仅当有保护映射时）
-			if len(protectedMap) > 0 {
-				fmt.Printf("\n[#%d] ", i+1)
-				fmt.Printf("原文: %s | ", originalText)
-				fmt.Printf("映射: %d\n", len(protectedMap))
-			}
+func newXLIFFMarkup() *xliffMarkup {
+	return &xliffMarkup{dataIDs: make(map[string]string)}
+}
 
-			// 还原被保护的内容（占位符和专有名词）
-			finalTranslation := restoreProtectedContent(translatedText, protectedMap)
+// 生成一段文本的行内内容（原文或译文）
+func (m *xliffMarkup) render(text string) string {
+	m.occurrences = make(map[string]int)
+	m.marks = 0
+	if nodes, err := parseICUMessage(text); err == nil && hasICUSelector(nodes) {
+		var sb strings.Builder
+		m.renderICU(&sb, nodes, false)
+		return sb.String()
+	}
+	return m.renderText(text)
+}
 
-			if len(protectedMap) > 0 {
-				if finalTranslation != translatedText {
-					fmt.Printf("     还原成功\n")
-				} else {
-					fmt.Printf("     ⚠️  警告: 没有还原任何内容！原文: %s | 翻译: %s\n", originalText, translatedText)
-				}
+// 普通文本：按翻译时的规则保护标签、占位符和专有名词
+func (m *xliffMarkup) renderText(text string) string {
+	protected, _ := protectAllContentWithGenerator(text, NewPlaceholderGenerator(m))
+	return protected
+}
 
-				// 检测是否有未还原的占位符
-				unrestoredPattern := regexp.MustCompile(`〈\d{4}〉`)
-				if unrestoredPattern.MatchString(finalTranslation) {
-					unrestoredMatches := unrestoredPattern.FindAllString(finalTranslation, -1)
-					fmt.Printf("     ❌ 错误: 检测到 %d 个未还原的占位符: %v\n", len(unrestoredMatches), unrestoredMatches)
-				}
+// 按语法树生成行内内容：选项之间的语法（如 "} other {"）写为一个 <ph>
+// 字面文本保持 ICU 转义形式，导入时按顺序拼接即可还原为合法的 ICU 消息
+func (m *xliffMarkup) renderICU(sb *strings.Builder, nodes []icuNode, inPlural bool) {
+	for _, node := range nodes {
+		switch n := node.(type) {
+		case *icuText:
+			sb.WriteString(m.renderText(escapeICUText(n.Value, inPlural)))
+		case *icuArgument:
+			sb.WriteString(m.ph(n.Raw))
+		case *icuPound:
+			sb.WriteString(m.ph("#"))
+		case *icuSelector:
+			open := "{" + n.Name + ", " + n.Kind + ","
+			if n.Offset != "" {
+				open += " offset:" + n.Offset
 			}
-
-			// 使用原始文本作为键保存结果
-			results[originalText] = finalTranslation
-			translationCache[originalText] = finalTranslation
-			fileCache[originalText] = CacheEntry{
-				Translation: finalTranslation,
-				Timestamp:   time.Now().Unix(),
+			for i, option := range n.Options {
+				if i > 0 {
+					open = "}"
+				}
+				sb.WriteString(m.ph(open + " " + option.Selector + " {"))
+				m.renderICU(sb, option.Message, inPlural || n.Kind != "select")
 			}
+			sb.WriteString(m.ph("}}"))
 		}
 	}
-
-	cacheMisses += len(toTranslate)
-	requestCount++
-	fmt.Printf("🔄 批量翻译 %d 个文本 (缓存命中: %d)\n", len(toTranslate), len(texts)-len(toTranslate))
-	return results, nil
 }
 
-// 根据目录名推断目标语言代码
-func inferLanguageFromDir(dirPath string) string {
-	// 从路径中提取目录名 (例如 "messages/zh-CN" -> "zh-CN")
-	dirName := filepath.Base(dirPath)
-
-	// 映射目录名到 DeepL 语言代码
-	dirMapping := map[string]string{
-		"en":     "EN",
-		"zh-cn":  "ZH",
-		"zh-tw":  "ZH",
-		"ja":     "JA",
-		"ko":     "KO",
-		"ar":     "AR",
-		"fr":     "FR",
-		"de":     "DE",
-		"it":     "IT",
-		"es":     "ES",
-		"pt":     "PT-BR",
-		"pt-br":  "PT-BR",
-		"ru":     "RU",
-		"nl":     "NL",
-		"sv":     "SV",
-		"da":     "DA",
-		"pl":     "PL",
-		"tr":     "TR",
-		"no":     "NO",
-		"fi":     "FI",
-	}
-
-	// 统一转小写并去除连字符变体，查询映射表
-	normalized := strings.ToLower(dirName)
-	if val, ok := dirMapping[normalized]; ok {
-		return val
-	}
-
-	// 如果精确匹配失败，尝试只用前两个字母匹配 (例如 "zh-CN" -> "zh")
-	parts := strings.Split(normalized, "-")
-	if len(parts) > 0 {
-		if val, ok := dirMapping[parts[0]]; ok {
-			return val
-		}
-	}
-
-	return "IT" // 默认语言（保持原逻辑）
-}
-
-// 将语言代码映射到 Google Cloud Translation 格式
-// Google 使用 ISO 639-1 代码 (en, zh, ja, ko, ar 等)
-func mapLanguageCode(code string) string {
-	mapping := map[string]string{
-		"EN":     "en",
-		"ZH":     "zh-CN",  // 简体中文
-		"ZH-CN": "zh-CN",
-		"ZH-TW": "zh-TW",  // 繁体中文
-		"DE":     "de",
-		"FR":     "fr",
-		"IT":     "it",
-		"ES":     "es",
-		"PT":     "pt",
-		"PT-BR": "pt",
-		"RU":     "ru",
-		"JA":     "ja",
-		"KO":     "ko",
-		"AR":     "ar",
-		"NL":     "nl",
-		"SV":     "sv",
-		"DA":     "da",
-		"PL":     "pl",
-		"TR":     "tr",
-		"NO":     "no",
-		"FI":     "fi",
-	}
-
-	upperCode := strings.ToUpper(code)
-	if val, ok := mapping[upperCode]; ok {
-		return val
-	}
-	return "en" // 默认英文
+// 富文本标签和占位符写为 <ph>，专有名词写为 <mrk>
+func (m *xliffMarkup) Wrap(id int, content string) string {
+	if !strings.HasPrefix(content, "<") && !strings.HasPrefix(content, "{") {
+		m.marks++
+		return fmt.Sprintf(`<mrk id="m%d" translate="no">%s</mrk>`, m.marks, xmlEscape(content))
+	}
+	return m.ph(content)
 }
 
-// 检查是否为纯占位符 - 只有占位符，没有其他文本
-func isPlaceholder(text string) bool {
-	// 如果文本完全由占位符组成，跳过翻译
-	// 例如："{name}", "{count}", "{progress}" 等
-	// 但 "Welcome back, {name}" 包含实际文本，应该被翻译
-
-	// 使用正则表达式检测纯占位符模式（只能是 {xxx}）
-	re := regexp.MustCompile(`^\{[a-zA-Z0-9_]+\}$`)
-	return re.MatchString(text)
+// 同一内容在文本中第 n 次出现时 ph id 加上序号，原文和译文中对应的 ph id 相同
+func (m *xliffMarkup) ph(content string) string {
+	dataID, ok := m.dataIDs[content]
+	if !ok {
+		m.data = append(m.data, content)
+		dataID = fmt.Sprintf("d%d", len(m.data))
+		m.dataIDs[content] = dataID
+	}
+	m.occurrences[content]++
+	phID := dataID
+	if n := m.occurrences[content]; n > 1 {
+		phID = fmt.Sprintf("%s-%d", dataID, n)
+	}
+	return fmt.Sprintf(`<ph id="%s" dataRef="%s" disp="%s"/>`, phID, dataID, html.EscapeString(content))
 }
 
-// 用于在单个翻译批次中生成唯一的占位符
-type PlaceholderGenerator struct {
-	counter int64
+func (m *xliffMarkup) Escape(text string) string {
+	return xmlEscape(text)
 }
 
-// 创建新的占位符生成器
-func NewPlaceholderGenerator() *PlaceholderGenerator {
-	return &PlaceholderGenerator{counter: 0}
+// 导入时按 XML 解析还原行内标记，不经过该方法
+func (m *xliffMarkup) Restore(translated string, protected map[int]string) (string, []int) {
+	return translated, nil
 }
 
-// 生成特殊占位符 - 使用不可翻译的格式
-// 格式：##XXXX##（四位数字）
-// 例如：##0001##、##0002##等
-// 双井号 + 数字的组合 DeepL 不会修改
-func (pg *PlaceholderGenerator) Generate() string {
-	pg.counter++
-	return fmt.Sprintf("##%04d##", pg.counter)
-}
+// 生成 XLIFF 2.0 文档：每个命名空间文件一个 <file>，每个键一个 <unit>（name 为键路径）
+func buildXLIFF(srcLang, trgLang string, entries []ExportEntry) []byte {
+	var sb strings.Builder
+	sb.WriteString(`<?xml version="1.0" encoding="UTF-8"?>` + "\n")
+	fmt.Fprintf(&sb, `<xliff xmlns="urn:oasis:names:tc:xliff:document:2.0" version="2.0" srcLang="%s" trgLang="%s">`+"\n", html.EscapeString(srcLang), html.EscapeString(trgLang))
+	currentFile := ""
+	files := 0
+	for i, entry := range entries {
+		if entry.File != currentFile {
+			if currentFile != "" {
+				sb.WriteString("  </file>\n")
+			}
+			currentFile = entry.File
+			files++
+			fmt.Fprintf(&sb, `  <file id="f%d" original="%s">`+"\n", files, html.EscapeString(entry.File))
+		}
 
-// 客户端保护：将占位符和专有名词替换为特殊标记，这样 DeepL 不会翻译它们
-func protectAllContentWithGenerator(text string, placeholderGen *PlaceholderGenerator) (string, map[string]string) {
-	result := text
-	protected := make(map[string]string)
+		markup := newXLIFFMarkup()
+		source := markup.render(entry.Source)
+		target := ""
+		if entry.Target != "" {
+			target = markup.render(entry.Target)
+		}
 
-	// 第一步：保护专有名词（先处理长的，避免部分替换）
-	// 按长度降序排列专有名词
-	type nounLen struct {
-		noun string
-		len  int
+		fmt.Fprintf(&sb, `    <unit id="u%d" name="%s">`+"\n", i+1, html.EscapeString(entry.Key))
+		if entry.Stale {
+			sb.WriteString(`      <notes><note category="status">The English source changed after this was translated; please review.</note></notes>` + "\n")
+		}
+		if len(markup.data) > 0 {
+			sb.WriteString("      <originalData>")
+			for j, data := range markup.data {
+				fmt.Fprintf(&sb, `<data id="d%d">%s</data>`, j+1, xmlEscape(data))
+			}
+			sb.WriteString("</originalData>\n")
+		}
+		state := "initial"
+		if entry.Target != "" && !entry.Stale {
+			state = "translated"
+		}
+		fmt.Fprintf(&sb, `      <segment state="%s">`+"\n", state)
+		fmt.Fprintf(&sb, "        <source>%s</source>\n", source)
+		if entry.Target != "" {
+			fmt.Fprintf(&sb, "        <target>%s</target>\n", target)
+		}
+		sb.WriteString("      </segment>\n    </unit>\n")
+	}
+	if currentFile != "" {
+		sb.WriteString("  </file>\n")
 	}
-	nouns := make([]nounLen, len(properNouns))
-	for i, noun := range properNouns {
-		nouns[i] = nounLen{noun, len(noun)}
+	sb.WriteString("</xliff>\n")
+	return []byte(sb.String())
+}
+
+// 解析 XLIFF 2.0 文档，返回目标语言和每个单元的原文、译文
+// 行内 <ph>、<pc>、<sc>/<ec> 按 originalData 还原，<mrk> 只保留其中的文字
+// state="initial" 或缺少 <target> 的单元视为未翻译，不导入
+func parseXLIFF(data []byte) (string, []ImportedTranslation, int, error) {
+	decoder := xml.NewDecoder(bytes.NewReader(data))
+	trgLang := ""
+	items := []ImportedTranslation{}
+	skipped := 0
+
+	var file, key string
+	var source, target strings.Builder
+	var originalData map[string]string
+	var pcEnds []string
+	var inSource, inTarget, inUnit, unitComplete, segmentHasTarget bool
+	var segmentSource strings.Builder
+
+	write := func(text string) {
+		if inSource {
+			source.WriteString(text)
+			segmentSource.WriteString(text)
+		} else if inTarget {
+			target.WriteString(text)
+		}
 	}
-	// 简单的降序排序（按长度）
-	for i := 0; i < len(nouns); i++ {
-		for j := i + 1; j < len(nouns); j++ {
-			if nouns[j].len > nouns[i].len {
-				nouns[i], nouns[j] = nouns[j], nouns[i]
+	attr := func(element xml.StartElement, name string) string {
+		for _, a := range element.Attr {
+			if a.Name.Local == name {
+				return a.Value
 			}
 		}
+		return ""
 	}
 
-	for _, nl := range nouns {
-		if strings.Contains(result, nl.noun) {
-			placeholder := placeholderGen.Generate()
-			protected[placeholder] = nl.noun
-			result = strings.ReplaceAll(result, nl.noun, placeholder)
+	for {
+		token, err := decoder.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return "", nil, 0, fmt.Errorf("解析 XLIFF 失败: %v", err)
+		}
+
+		switch t := token.(type) {
+		case xml.StartElement:
+			switch t.Name.Local {
+			case "xliff":
+				if version := attr(t, "version"); !strings.HasPrefix(version, "2.") {
+					return "", nil, 0, fmt.Errorf("只支持 XLIFF 2.x，文件版本为 %q", version)
+				}
+				trgLang = attr(t, "trgLang")
+			case "file":
+				file = attr(t, "original")
+			case "unit":
+				key = attr(t, "name")
+				source.Reset()
+				target.Reset()
+				originalData = make(map[string]string)
+				inUnit, unitComplete = true, true
+			case "data":
+				var content struct {
+					Text string `xml:",chardata"`
+				}
+				if err := decoder.DecodeElement(&content, &t); err != nil {
+					return "", nil, 0, fmt.Errorf("解析 originalData 失败: %v", err)
+				}
+				originalData[attr(t, "id")] = content.Text
+			case "segment", "ignorable":
+				segmentHasTarget = false
+				segmentSource.Reset()
+				if t.Name.Local == "segment" && attr(t, "state") == "initial" {
+					unitComplete = false
+				}
+			case "source":
+				inSource = true
+			case "target":
+				inTarget = true
+				segmentHasTarget = true
+			case "ph", "sc", "ec":
+				write(originalData[attr(t, "dataRef")])
+			case "pc":
+				write(originalData[attr(t, "dataRefStart")])
+				pcEnds = append(pcEnds, originalData[attr(t, "dataRefEnd")])
+			}
+		case xml.EndElement:
+			switch t.Name.Local {
+			case "source":
+				inSource = false
+			case "target":
+				inTarget = false
+			case "pc":
+				if len(pcEnds) > 0 {
+					write(pcEnds[len(pcEnds)-1])
+					pcEnds = pcEnds[:len(pcEnds)-1]
+				}
+			case "segment":
+				if !segmentHasTarget {
+					unitComplete = false
+				}
+			case "ignorable":
+				// 可忽略的片段（如句间空白）没有译文时沿用原文
+				if !segmentHasTarget {
+					target.WriteString(segmentSource.String())
+				}
+			case "unit":
+				if inUnit && unitComplete {
+					items = append(items, ImportedTranslation{File: file, Key: key, Source: source.String(), Target: target.String()})
+				} else {
+					skipped++
+				}
+				inUnit = false
+			}
+		case xml.CharData:
+			if inUnit {
+				write(string(t))
+			}
 		}
 	}
+	return trgLang, items, skipped, nil
+}
 
-	// 第二步：保护占位符（如 {name}, {count} 等）
-	// 只匹配原始占位符（包含字母下划线的），不匹配已生成的数字占位符
-	originalPlaceholderRegex := regexp.MustCompile(`\{[a-zA-Z_][a-zA-Z0-9_]*\}`)
-	result = originalPlaceholderRegex.ReplaceAllStringFunc(result, func(match string) string {
-		placeholder := placeholderGen.Generate()
-		protected[placeholder] = match
-		return placeholder
-	})
+// 生成 Gettext PO 文件：msgctxt 为 "命名空间:键路径"，msgid 为英文原文
+// 原文已变化的译文标记为 fuzzy，注释中写明所在文件、键路径和不能修改的占位符/专有名词
+func buildPO(srcLang, trgLang string, entries []ExportEntry) []byte {
+	var sb strings.Builder
+	sb.WriteString("msgid \"\"\nmsgstr \"\"\n")
+	for _, header := range []string{
+		"Project-Id-Version: fluxreve.com messages",
+		"Language: " + trgLang,
+		"MIME-Version: 1.0",
+		"Content-Type: text/plain; charset=UTF-8",
+		"Content-Transfer-Encoding: 8bit",
+		"X-Source-Language: " + srcLang,
+	} {
+		sb.WriteString(poQuote(header+"\n") + "\n")
+	}
 
-	return result, protected
+	for _, entry := range entries {
+		sb.WriteString("\n")
+		fmt.Fprintf(&sb, "#. %s: %s\n", entry.File, entry.Key)
+		if keep := protectedTerms(entry.Source); len(keep) > 0 {
+			fmt.Fprintf(&sb, "#. Keep unchanged: %s\n", strings.Join(keep, ", "))
+		}
+		if entry.Stale {
+			sb.WriteString("#, fuzzy\n")
+		}
+		writePOString(&sb, "msgctxt", strings.TrimSuffix(entry.File, ".json")+":"+entry.Key)
+		writePOString(&sb, "msgid", entry.Source)
+		writePOString(&sb, "msgstr", entry.Target)
+	}
+	return []byte(sb.String())
 }
 
-// 还原被保护的内容
-// 占位符格式：##XXXX##（四位数字）
-// 还原步骤：
-// 1. 从 protected map 中提取所有数字 ID
-// 2. 先删除所有 # 字符
-// 3. 用纯数字去匹配并替换
-func restoreProtectedContent(text string, protected map[string]string) string {
-	// 构建数字ID到内容的映射
-	// 从 protected map 中提取数字 ID
-	idMap := make(map[string]string)
-	for placeholder, content := range protected {
-		// 从 ##0001## 中提取 0001（删除所有 #）
-		numID := strings.Trim(placeholder, "#")
-		idMap[numID] = content
+// 文本中受保护的内容（占位符、富文本标签、专有名词），去重并保持出现顺序
+func protectedTerms(text string) []string {
+	_, protected := protectAllContentWithGenerator(text, NewPlaceholderGenerator(newSentinelMarkup(text)))
+	terms := []string{}
+	seen := make(map[string]bool)
+	for id := 1; id <= len(protected); id++ {
+		if term := protected[id]; !seen[term] {
+			seen[term] = true
+			terms = append(terms, term)
+		}
 	}
+	return terms
+}
 
-	// 第一步：删除所有的 # 字符
-	result := strings.ReplaceAll(text, "#", "")
+// 写入一个 PO 字段；多行文本按 gettext 惯例从空字符串开始，每行单独加引号
+func writePOString(sb *strings.Builder, keyword, value string) {
+	lines := strings.SplitAfter(value, "\n")
+	if lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	if len(lines) <= 1 {
+		fmt.Fprintf(sb, "%s %s\n", keyword, poQuote(value))
+		return
+	}
+	fmt.Fprintf(sb, "%s \"\"\n", keyword)
+	for _, line := range lines {
+		sb.WriteString(poQuote(line) + "\n")
+	}
+}
 
-	// 第二步：用正则表达式匹配所有 4 位数字，检查是否在 idMap 中
-	// 只替换那些在 idMap 中的数字
-	digitPattern := regexp.MustCompile(`\d{4}`)
-	result = digitPattern.ReplaceAllStringFunc(result, func(match string) string {
-		if originalContent, ok := idMap[match]; ok {
-			return originalContent
-		}
-		return match
-	})
+var poEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`, "\t", `\t`, "\r", `\r`)
 
-	return result
+func poQuote(text string) string {
+	return `"` + poEscaper.Replace(text) + `"`
 }
 
-// 第一步：收集所有需要翻译的文本
-func collectTexts(data interface{}, texts map[string]bool) {
-	switch v := data.(type) {
-	case map[string]interface{}:
-		for _, value := range v {
-			collectTexts(value, texts)
-		}
-	case []interface{}:
-		for _, value := range v {
-			collectTexts(value, texts)
+// 解析带引号的 PO 字符串（C 风格转义）
+func poUnquote(text string) (string, error) {
+	if len(text) < 2 || text[0] != '"' || text[len(text)-1] != '"' {
+		return "", fmt.Errorf("字符串缺少引号: %s", text)
+	}
+	var sb strings.Builder
+	body := text[1 : len(text)-1]
+	for i := 0; i < len(body); i++ {
+		if body[i] != '\\' || i+1 == len(body) {
+			sb.WriteByte(body[i])
+			continue
 		}
-	case string:
-		if len(v) > 0 && !isPlaceholder(v) {
-			texts[v] = true
+		i++
+		switch body[i] {
+		case 'n':
+			sb.WriteByte('\n')
+		case 't':
+			sb.WriteByte('\t')
+		case 'r':
+			sb.WriteByte('\r')
+		default:
+			sb.WriteByte(body[i])
 		}
 	}
+	return sb.String(), nil
 }
 
-// 第二步：递归替换翻译后的文本
-func translateJSON(data interface{}, translations map[string]string) interface{} {
-	switch v := data.(type) {
-	case map[string]interface{}:
-		result := make(map[string]interface{})
-		for key, value := range v {
-			result[key] = translateJSON(value, translations)
+// PO 文件中的一个条目
+type poEntry struct {
+	context, id, str string
+	hasContext       bool
+	hasStr           bool
+	fuzzy, plural    bool
+}
+
+// 解析 PO 文件，返回头部的 Language、译文和跳过的条目数
+// fuzzy、复数形式和没有译文的条目不导入；已废弃的条目（#~）直接忽略
+func parsePO(data []byte) (string, []ImportedTranslation, int, error) {
+	trgLang := ""
+	items := []ImportedTranslation{}
+	skipped := 0
+
+	entry := &poEntry{}
+	var field *string
+	flush := func() error {
+		defer func() { entry, field = &poEntry{}, nil }()
+		if !entry.hasStr {
+			return nil
 		}
-		return result
-	case []interface{}:
-		result := make([]interface{}, len(v))
-		for i, value := range v {
-			result[i] = translateJSON(value, translations)
+		if entry.id == "" && !entry.hasContext {
+			for _, header := range strings.Split(entry.str, "\n") {
+				if value := strings.TrimPrefix(header, "Language:"); value != header {
+					trgLang = strings.TrimSpace(value)
+				}
+			}
+			return nil
 		}
-		return result
-	case string:
-		if len(v) == 0 || isPlaceholder(v) {
-			return v
+		if entry.plural || entry.fuzzy || entry.str == "" {
+			skipped++
+			return nil
 		}
-		if translated, ok := translations[v]; ok {
-			return translated
+		namespace, key, ok := strings.Cut(entry.context, ":")
+		if !entry.hasContext || !ok || namespace == "" || key == "" {
+			return fmt.Errorf("条目 %q 缺少 \"命名空间:键路径\" 格式的 msgctxt", entry.id)
 		}
-		return v
-	default:
-		return v
+		items = append(items, ImportedTranslation{File: namespace + ".json", Key: key, Source: entry.id, Target: entry.str})
+		return nil
+	}
+
+	var discard string
+	for i, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		keyword, value, _ := strings.Cut(line, " ")
+		var err error
+		switch {
+		case line == "":
+			err = flush()
+		case strings.HasPrefix(line, "#~"):
+			continue
+		case strings.HasPrefix(line, "#"):
+			if entry.hasStr {
+				err = flush()
+			}
+			if strings.HasPrefix(line, "#,") {
+				for _, option := range strings.Split(line[2:], ",") {
+					entry.fuzzy = entry.fuzzy || strings.TrimSpace(option) == "fuzzy"
+				}
+			}
+		case strings.HasPrefix(line, `"`):
+			if field == nil {
+				return "", nil, 0, fmt.Errorf("第 %d 行: 字符串前缺少关键字", i+1)
+			}
+			var text string
+			text, err = poUnquote(line)
+			*field += text
+		default:
+			if entry.hasStr && (keyword == "msgctxt" || keyword == "msgid") {
+				if err := flush(); err != nil {
+					return "", nil, 0, err
+				}
+			}
+			switch {
+			case keyword == "msgctxt":
+				field, entry.hasContext = &entry.context, true
+			case keyword == "msgid":
+				field = &entry.id
+			case keyword == "msgid_plural":
+				field, entry.plural = &discard, true
+			case keyword == "msgstr":
+				field, entry.hasStr = &entry.str, true
+			case strings.HasPrefix(keyword, "msgstr["):
+				field, entry.hasStr, entry.plural = &discard, true, true
+			default:
+				return "", nil, 0, fmt.Errorf("第 %d 行: 无法识别的内容: %s", i+1, line)
+			}
+			*field, err = poUnquote(value)
+		}
+		if err != nil {
+			return "", nil, 0, fmt.Errorf("第 %d 行: %v", i+1, err)
+		}
+	}
+	if err := flush(); err != nil {
+		return "", nil, 0, err
 	}
+	return trgLang, items, skipped, nil
 }
 
-// 处理单个文件
-func processFile(sourceFile, targetDir, apiKey, targetLang string) error {
-	fileName := filepath.Base(sourceFile)
-	fmt.Printf("\n📄 处理文件: %s\n", fileName)
+// 人工翻译交换格式：导出时生成文件内容，导入时解析出目标语言、译文和跳过的条目数
+type exchangeFormat struct {
+	Extension string
+	Build     func(srcLang, trgLang string, entries []ExportEntry) []byte
+	Parse     func(data []byte) (string, []ImportedTranslation, int, error)
+}
 
-	// 加载该文件的缓存
-	fileCache := loadFileCache(targetDir, fileName)
+var exchangeFormats = map[string]exchangeFormat{
+	"xliff": {Extension: ".xlf", Build: buildXLIFF, Parse: parseXLIFF},
+	"po":    {Extension: ".po", Build: buildPO, Parse: parsePO},
+}
 
-	// 读取源文件
-	data, err := ioutil.ReadFile(sourceFile)
-	if err != nil {
-		return fmt.Errorf("读取文件失败: %v", err)
+// 按名称查找交换格式
+func lookupExchangeFormat(args []string, example string) (string, exchangeFormat, bool) {
+	if len(args) == 0 {
+		fmt.Printf("❌ 错误: 请指定格式，如: %s\n", example)
+		return "", exchangeFormat{}, false
 	}
+	format, ok := exchangeFormats[args[0]]
+	if !ok {
+		fmt.Printf("❌ 错误: 不支持的格式: %s (可选: xliff, po)\n", args[0])
+	}
+	return args[0], format, ok
+}
 
-	// 解析 JSON
-	var jsonData interface{}
-	if err := json.Unmarshal(data, &jsonData); err != nil {
-		return fmt.Errorf("解析 JSON 失败: %v", err)
+// export 子命令：导出给人工翻译的文件（export xliff、export po）
+func runExportCommand(args []string) int {
+	name, format, ok := lookupExchangeFormat(args, "export xliff -target ./messages/de")
+	if !ok {
+		return 1
 	}
 
-	// 第一步：收集所有需要翻译的文本
-	textsToTranslate := make(map[string]bool)
-	collectTexts(jsonData, textsToTranslate)
+	fs := flag.NewFlagSet("export "+name, flag.ExitOnError)
+	sourceDir := fs.String("source", "./messages/en", "源文件目录")
+	targetDir := fs.String("target", "./messages/it", "目标语言目录 (已有译文一并导出)")
+	output := fs.String("output", "", "输出文件 (默认 <locale>"+format.Extension+")")
+	pendingOnly := fs.Bool("pending", false, "只导出缺少译文或英文原文已变化的键")
+	lockDir := fs.String("lock-dir", defaultLockDir, "翻译锁文件目录")
+	fs.Parse(args[1:])
 
-	// 转换为数组
-	textArray := make([]string, 0, len(textsToTranslate))
-	for text := range textsToTranslate {
-		textArray = append(textArray, text)
+	lockRootDir = *lockDir
+	if err := loadProperNounsConfig("./config/proper-nouns.json"); err != nil {
+		fmt.Printf("⚠️  警告: 加载专有名词配置失败: %v\n", err)
 	}
 
-	// 第二步：批量翻译
-	translations, err := translateWithGoogleBatch(apiKey, textArray, targetLang, fileCache)
-	if err != nil {
-		return fmt.Errorf("翻译失败: %v", err)
+	locale := filepath.Base(filepath.Clean(*targetDir))
+	if *output == "" {
+		*output = locale + format.Extension
 	}
-
-	// 第三步：递归替换翻译后的文本
-	translatedData := translateJSON(jsonData, translations)
-
-	// 转换回 JSON（带缩进）
-	// 使用自定义 encoder 来避免 HTML 转义
-	var buf bytes.Buffer
-	encoder := json.NewEncoder(&buf)
-	encoder.SetEscapeHTML(false)  // 禁用 HTML 转义，保持原样输出
-	encoder.SetIndent("", "  ")
-	if err := encoder.Encode(translatedData); err != nil {
-		return fmt.Errorf("序列化 JSON 失败: %v", err)
+	entries, err := collectExportEntries(*sourceDir, *targetDir, *pendingOnly)
+	if err != nil {
+		fmt.Printf("❌ 错误: %v\n", err)
+		return 1
 	}
-	translated := buf.Bytes()
-	// 移除末尾的换行符（Encode 会添加一个）
-	translated = bytes.TrimSuffix(translated, []byte("\n"))
-
-	// 确保目标目录存在
-	if err := os.MkdirAll(targetDir, 0755); err != nil {
-		return fmt.Errorf("创建目录失败: %v", err)
+	srcLang := filepath.Base(filepath.Clean(*sourceDir))
+	if err := ioutil.WriteFile(*output, format.Build(srcLang, locale, entries), 0644); err != nil {
+		fmt.Printf("❌ 错误: 写入文件失败: %v\n", err)
+		return 1
 	}
+	fmt.Printf("✅ 已导出 %d 个键到 %s\n", len(entries), *output)
+	return 0
+}
 
-	// 写入目标文件
-	targetFile := filepath.Join(targetDir, fileName)
-	if err := ioutil.WriteFile(targetFile, translated, 0644); err != nil {
-		return fmt.Errorf("写入文件失败: %v", err)
+// import 子命令：把人工翻译的文件合并回 messages/<locale>/*.json（import xliff、import po）
+func runImportCommand(args []string) int {
+	name, format, ok := lookupExchangeFormat(args, "import xliff de.xlf")
+	if !ok {
+		return 1
 	}
 
-	fmt.Printf("✅ 已保存: %s\n", targetFile)
+	fs := flag.NewFlagSet("import "+name, flag.ExitOnError)
+	sourceDir := fs.String("source", "./messages/en", "源文件目录")
+	targetDir := fs.String("target", "", "目标语言目录 (默认按文件中的目标语言写入 messages/<locale>)")
+	lockDir := fs.String("lock-dir", defaultLockDir, "翻译锁文件目录")
+	fs.Parse(args[1:])
 
-	// 保存该文件的缓存
-	if err := saveFileCache(targetDir, fileName, fileCache); err != nil {
-		fmt.Printf("⚠️  缓存保存失败: %v\n", err)
+	if fs.NArg() != 1 {
+		fmt.Printf("❌ 错误: 请指定要导入的文件，如: import %s -target ./messages/de de%s\n", name, format.Extension)
+		return 1
+	}
+	lockRootDir = *lockDir
+	if err := loadProperNounsConfig("./config/proper-nouns.json"); err != nil {
+		fmt.Printf("⚠️  警告: 加载专有名词配置失败: %v\n", err)
 	}
 
-	return nil
-}
-
-// 批量处理目录
-func processDirectory(sourceDir, targetDir, apiKey, targetLang string) error {
-	files, err := ioutil.ReadDir(sourceDir)
+	data, err := ioutil.ReadFile(fs.Arg(0))
 	if err != nil {
-		return fmt.Errorf("读取目录失败: %v", err)
+		fmt.Printf("❌ 错误: 读取文件失败: %v\n", err)
+		return 1
 	}
-
-	fmt.Printf("📂 找到 %d 个文件\n\n", countJSONFiles(files))
-
-	for _, file := range files {
-		if !file.IsDir() && strings.HasSuffix(file.Name(), ".json") {
-			sourcePath := filepath.Join(sourceDir, file.Name())
-			if err := processFile(sourcePath, targetDir, apiKey, targetLang); err != nil {
-				fmt.Printf("❌ 错误: %v\n", err)
-				// 继续处理其他文件
-			}
+	trgLang, items, skipped, err := format.Parse(data)
+	if err != nil {
+		fmt.Printf("❌ 错误: %v\n", err)
+		return 1
+	}
+	if *targetDir == "" {
+		if trgLang == "" {
+			fmt.Println("❌ 错误: 文件中没有目标语言，请使用 -target 指定目标目录")
+			return 1
 		}
+		*targetDir = filepath.Join(filepath.Dir(filepath.Clean(*sourceDir)), filepath.Base(trgLang))
+	}
+	if skipped > 0 {
+		fmt.Printf("⏭️  跳过 %d 个未翻译的条目\n", skipped)
 	}
 
-	return nil
+	applied, failures, err := importTranslations(*sourceDir, *targetDir, items)
+	if err != nil {
+		fmt.Printf("❌ 错误: %v\n", err)
+		return 1
+	}
+	return reportImport(applied, failures)
 }
 
-// 统计 JSON 文件数量
-func countJSONFiles(files []os.FileInfo) int {
-	count := 0
-	for _, file := range files {
-		if !file.IsDir() && strings.HasSuffix(file.Name(), ".json") {
-			count++
+// 翻译锁文件默认目录
+const defaultLockDir = "./messages/.i18n-lock"
+
+func main() {
+	// 子命令
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "stale":
+			os.Exit(runStaleCommand(os.Args[2:]))
+		case "check":
+			os.Exit(runCheckCommand(os.Args[2:]))
+		case "merge":
+			os.Exit(runMergeCommand(os.Args[2:]))
+		case "pseudo":
+			os.Exit(runPseudoCommand(os.Args[2:]))
+		case "export":
+			os.Exit(runExportCommand(os.Args[2:]))
+		case "import":
+			os.Exit(runImportCommand(os.Args[2:]))
 		}
 	}
-	return count
-}
 
-func main() {
-	apiKey := flag.String("key", "", "DeepL API 密钥 (必需)")
+	apiKey := flag.String("key", "", "翻译服务 API 密钥 (必需)")
+	provider := flag.String("provider", "google", "翻译服务: google、deepl、openai (OpenAI 兼容的 LLM 接口) 或 opencc (离线简繁转换，源目录为 messages/zh-CN)")
+	llmBaseURL := flag.String("llm-base-url", "https://api.openai.com/v1", "LLM 接口地址 (仅 openai 服务)")
+	llmModel := flag.String("llm-model", "gpt-4o-mini", "LLM 模型名称 (仅 openai 服务)")
+	googleToken := flag.String("google-token", "", "Google OAuth 访问令牌 (仅 google 服务使用 v3 术语表时需要，可用 gcloud auth print-access-token 获取)")
 	sourceDir := flag.String("source", "./messages/en", "源文件目录")
 	targetDir := flag.String("target", "./messages/it", "目标文件目录")
-	targetLang := flag.String("lang", "", "目标语言代码 (可选，默认从目标目录名自动推断)")
+	targetLang := flag.String("lang", "", "目标语言 (可选，BCP-47 标签如 zh-Hant 或内部代码如 DE，默认按目标目录名查找)")
 	singleFile := flag.String("file", "", "单个文件模式: 要翻译的文件路径")
+	incremental := flag.Bool("incremental", false, "增量模式: 保留目标文件中已有的翻译，只翻译缺失或英文原文已变化的键")
+	lockDir := flag.String("lock-dir", defaultLockDir, "翻译锁文件目录 (记录每个键的原文哈希)")
+	concurrency := flag.Int("concurrency", 1, "并发 worker 数量 (跨文件和语言)")
+	requestsPerSecond := flag.Float64("rps", 2, "每秒最多请求数 (所有 worker 共享，0 表示不限速)")
+	burst := flag.Int("burst", 1, "速率限制允许的突发请求数")
+	maxAttempts := flag.Int("max-attempts", retryPolicy.MaxAttempts, "每批请求最多尝试次数 (遇到 429/5xx/网络超时时重试)")
+	retryBaseDelay := flag.Duration("retry-delay", retryPolicy.BaseDelay, "首次重试的基础等待时间 (之后指数增长)")
+	allLocales := flag.Bool("all", false, "全部语言模式: 按 config/locales.js 中的语言列表翻译到所有 messages/<locale> 目录")
+	retryUntranslatedFlag := flag.Bool("retry-untranslated", false, "疑似未翻译的译文 (与英文相同或不是目标语言) 跳过缓存重新翻译一次")
+	shorten := flag.Bool("shorten", false, "超出 config/length-rules.json 长度限制的译文请 LLM 缩短 (仅 openai 服务)")
+	mergeAfter := flag.Bool("merge", false, "翻译完成后合并为 messages/<locale>.json (同 merge 子命令)")
 
 	flag.Parse()
 
 	// 初始化缓存根目录 - .deepl_cache
 	cacheRootDir = ".deepl_cache"
+	incrementalMode = *incremental
+	retryUntranslated = *retryUntranslatedFlag
+	shortenOverLength = *shorten
+	lockRootDir = *lockDir
+	workerCount = *concurrency
+	rateLimiter = NewRateLimiter(*requestsPerSecond, *burst)
+	retryPolicy.MaxAttempts = *maxAttempts
+	retryPolicy.BaseDelay = *retryBaseDelay
 
 	// 加载专有名词配置
 	if err := loadProperNounsConfig("./config/proper-nouns.json"); err != nil {
 		fmt.Printf("⚠️  警告: 加载专有名词配置失败: %v\n", err)
 	}
+	if err := loadGlossaryConfig("./config/glossary.json"); err != nil {
+		fmt.Printf("⚠️  警告: 加载术语表配置失败: %v\n", err)
+	}
+	if err := loadLengthRulesConfig("./config/length-rules.json"); err != nil {
+		fmt.Printf("⚠️  警告: 加载长度限制配置失败: %v\n", err)
+	}
+	protectionFingerprint = computeProtectionFingerprint()
+
+	// 服务名称不区分大小写（与 newTranslator 一致）
+	*provider = strings.ToLower(*provider)
 
-	if *apiKey == "" {
-		fmt.Println("❌ 错误: 必须提供 -key 参数（Google Cloud Translation API 密钥）")
+	// 本地替身服务等自定义 LLM 接口可能不需要密钥，离线简繁转换不需要密钥
+	offline := *provider == "opencc"
+	keyOptional := offline || *provider == "openai" && *llmBaseURL != "https://api.openai.com/v1"
+	if *apiKey == "" && !keyOptional {
+		fmt.Println("❌ 错误: 必须提供 -key 参数（翻译服务 API 密钥）")
 		fmt.Println("\n📖 使用方法:")
-		fmt.Println("  批量翻译 (自动推断语言):  go run translate-deepl.go -key YOUR_API_KEY -target ./messages/zh-CN")
-		fmt.Println("  单个文件 (自动推断语言):  go run translate-deepl.go -key YOUR_API_KEY -file ./messages/en/common.json -target ./messages/it")
-		fmt.Println("  指定语言 (手动覆盖):    go run translate-deepl.go -key YOUR_API_KEY -target ./messages/fr -lang FR")
+		fmt.Println("  批量翻译 (自动推断语言):  go run scripts/translate-google.go -key YOUR_API_KEY -target ./messages/zh-CN")
+		fmt.Println("  单个文件 (自动推断语言):  go run scripts/translate-google.go -key YOUR_API_KEY -file ./messages/en/common.json -target ./messages/it")
+		fmt.Println("  指定语言 (手动覆盖):    go run scripts/translate-google.go -key YOUR_API_KEY -target ./messages/fr -lang FR")
+		fmt.Println("  全部语言:               go run scripts/translate-google.go -key YOUR_API_KEY -all -incremental")
+		fmt.Println("  使用 DeepL:             go run scripts/translate-google.go -provider deepl -key YOUR_DEEPL_KEY -target ./messages/de")
+		fmt.Println("  使用 LLM:               go run scripts/translate-google.go -provider openai -key YOUR_OPENAI_KEY -llm-model gpt-4o -target ./messages/de")
+		fmt.Println("  简繁转换 (离线):        go run scripts/translate-google.go -provider opencc -source ./messages/zh-CN -target ./messages/zh-TW")
 		fmt.Println("\n💡 获取 API 密钥: https://cloud.google.com/docs/authentication/api-keys")
+		fmt.Println("               https://www.deepl.com/pro-api")
 		os.Exit(1)
 	}
 
-	// 如果未提供 -lang 参数，根据目标目录自动推断语言代码
-	if *targetLang == "" {
-		*targetLang = inferLanguageFromDir(*targetDir)
+	translator, err := newTranslator(*provider, *apiKey, LLMConfig{BaseURL: *llmBaseURL, Model: *llmModel})
+	if err != nil {
+		fmt.Printf("❌ 错误: %v\n", err)
+		os.Exit(1)
+	}
+	if google, ok := translator.(*GoogleTranslator); ok {
+		google.accessToken = *googleToken
+	}
+
+	// 简繁转换只能以简体中文为源，本地转换不限速
+	if offline {
+		sourceLocaleDir := *sourceDir
+		if *singleFile != "" {
+			sourceLocaleDir = filepath.Dir(*singleFile)
+		}
+		info, err := localeForDir(sourceLocaleDir)
+		if err != nil || info.Tag != "zh-Hans" {
+			fmt.Printf("❌ 错误: opencc 服务的源目录必须是简体中文 (如 ./messages/zh-CN)，当前: %s\n", sourceLocaleDir)
+			os.Exit(1)
+		}
+		rateLimiter = NewRateLimiter(0, 1)
+	}
+
+	// 全部语言模式：从语言配置中读取目标语言列表
+	var locales []string
+	var defaultLocale string
+	if *allLocales {
+		locales, defaultLocale, err = loadLocaleConfig(localeConfigPaths)
+		if err != nil {
+			fmt.Printf("❌ 错误: %v\n", err)
+			os.Exit(1)
+		}
+		fmt.Printf("✅ 读取到 %d 个语言 (默认语言: %s)\n", len(locales), defaultLocale)
+	}
+
+	// 目标语言：-lang 参数（标签或内部代码）优先，否则按目标目录名查找，不认识的语言直接报错
+	if !*allLocales {
+		langSource := *targetLang
+		if langSource == "" {
+			langSource = filepath.Base(filepath.Clean(*targetDir))
+		}
+		info, err := resolveLocale(langSource)
+		if err != nil {
+			fmt.Printf("❌ 错误: %v\n", err)
+			os.Exit(1)
+		}
+		*targetLang = info.Code
+	}
+
+	if !*allLocales && !supportsLanguage(translator, *targetLang) {
+		fmt.Printf("❌ 错误: %s 不支持目标语言 %s\n", translator.Name(), *targetLang)
+		os.Exit(1)
 	}
 
 	fmt.Printf("\n%s\n", strings.Repeat("=", 60))
-	fmt.Printf("🌐 Google Cloud Translation 翻译脚本 (带缓存机制)\n")
+	fmt.Printf("🌐 %s 翻译脚本 (带缓存机制)\n", translator.Name())
 	fmt.Printf("%s\n", strings.Repeat("=", 60))
 	fmt.Printf("📍 源目录:   %s\n", *sourceDir)
-	fmt.Printf("📍 目标目录: %s\n", *targetDir)
-	fmt.Printf("🔤 目标语言: %s\n", *targetLang)
+	if *allLocales {
+		fmt.Printf("📍 目标目录: %s/<locale> (%d 个语言)\n", filepath.Dir(filepath.Clean(*sourceDir)), len(targetLocales(locales, defaultLocale)))
+	} else {
+		fmt.Printf("📍 目标目录: %s\n", *targetDir)
+		fmt.Printf("🔤 目标语言: %s\n", *targetLang)
+	}
 	fmt.Printf("💾 缓存根目录: %s\n", cacheRootDir)
-	fmt.Printf("⏱️  缓存有效期: 24 小时\n")
+	fmt.Printf("🔒 锁文件目录: %s\n", lockRootDir)
+	fmt.Printf("⚡ 并发数: %d | 速率限制: %.1f 请求/秒\n", workerCount, *requestsPerSecond)
+	if incrementalMode {
+		fmt.Printf("♻️  增量模式: 保留已有翻译\n")
+	}
 	fmt.Printf("%s\n\n", strings.Repeat("=", 60))
 
 	startTime := time.Now()
 
-	if *singleFile != "" {
+	var summaries []LocaleSummary
+	failedFiles := 0
+	if *allLocales {
+		// 全部语言模式
+		summaries = translateAllLocales(*sourceDir, locales, defaultLocale, translator)
+	} else if *singleFile != "" {
 		// 单文件模式
-		if err := processFile(*singleFile, *targetDir, *apiKey, *targetLang); err != nil {
+		if err := processFile(*singleFile, *targetDir, translator, *targetLang, nil); err != nil {
 			fmt.Printf("❌ 错误: %v\n", err)
 			os.Exit(1)
 		}
 	} else {
 		// 批量模式
-		if err := processDirectory(*sourceDir, *targetDir, *apiKey, *targetLang); err != nil {
+		_, failedFiles, err = processDirectory(*sourceDir, *targetDir, translator, *targetLang)
+		if err != nil {
 			fmt.Printf("❌ 错误: %v\n", err)
 			os.Exit(1)
 		}
@@ -721,11 +6294,44 @@ func main() {
 	elapsed := time.Since(startTime)
 	fmt.Printf("\n%s\n", strings.Repeat("=", 60))
 	fmt.Printf("✅ 翻译完成！\n")
+	requestCount, cacheHits, cacheMisses := totalStats.Requests.Load(), totalStats.CacheHits.Load(), totalStats.CacheMisses.Load()
 	fmt.Printf("📊 API 请求: %d | 缓存命中: %d | 缓存未命中: %d\n", requestCount, cacheHits, cacheMisses)
 	if requestCount > 0 {
 		hitRate := float64(cacheHits) / float64(cacheHits+cacheMisses) * 100
 		fmt.Printf("💾 缓存命中率: %.1f%%\n", hitRate)
 	}
 	fmt.Printf("⏱️  耗时: %.2f 秒\n", elapsed.Seconds())
+	if len(summaries) > 0 {
+		printLocaleSummaries(os.Stdout, summaries)
+	}
+	validationFailures.Print("⚠️  译文校验失败 %d 个键（保留原有译文，没有时使用英文原文）:")
+	untranslatedWarnings.Print("⚠️  疑似未翻译 %d 个键（已写入，请人工检查）:")
+	lengthViolations.Print("⚠️  超出长度限制 %d 个键（已写入）:")
 	fmt.Printf("%s\n\n", strings.Repeat("=", 60))
+
+	// 合并模式：源语言和所有目标语言一起生成合并文件
+	mergeFailed := 0
+	if *mergeAfter {
+		mergeLocales := []string{filepath.Base(filepath.Clean(*sourceDir))}
+		if *allLocales {
+			for _, locale := range locales {
+				if locale != mergeLocales[0] {
+					mergeLocales = append(mergeLocales, locale)
+				}
+			}
+		} else {
+			mergeLocales = append(mergeLocales, filepath.Base(filepath.Clean(*targetDir)))
+		}
+		mergeFailed = mergeMessages(filepath.Dir(filepath.Clean(*sourceDir)), mergeLocales)
+	}
+
+	// 任何文件或语言失败时返回非零退出码
+	if failedFiles > 0 || mergeFailed > 0 {
+		os.Exit(1)
+	}
+	for _, summary := range summaries {
+		if summary.Err != nil || summary.Failed > 0 {
+			os.Exit(1)
+		}
+	}
 }
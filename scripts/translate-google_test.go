@@ -0,0 +1,1452 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// 运行方式：go test -bench . scripts/translate-google.go scripts/translate-google_test.go
+
+// 启动本地替身服务：记录最后一次请求的路径、查询参数、请求头和 JSON 请求体，返回固定响应
+type fakeAPI struct {
+	server  *httptest.Server
+	path    string
+	query   string
+	header  http.Header
+	payload map[string]interface{}
+}
+
+func newFakeAPI(t *testing.T, response string) *fakeAPI {
+	api := &fakeAPI{}
+	api.server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		api.path, api.query, api.header = r.URL.Path, r.URL.RawQuery, r.Header
+		api.payload = nil
+		if err := json.NewDecoder(r.Body).Decode(&api.payload); err != nil {
+			t.Errorf("request body is not JSON: %v", err)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(response))
+	}))
+	t.Cleanup(api.server.Close)
+	return api
+}
+
+// 取出请求体中的字符串数组字段
+func payloadStrings(payload map[string]interface{}, field string) []string {
+	values := []string{}
+	items, _ := payload[field].([]interface{})
+	for _, item := range items {
+		value, _ := item.(string)
+		values = append(values, value)
+	}
+	return values
+}
+
+func TestGoogleTranslator(t *testing.T) {
+	api := newFakeAPI(t, `{"data": {"translations": [{"translatedText": "Hallo"}, {"translatedText": "Welt"}]}}`)
+	google := NewGoogleTranslator("secret")
+	google.baseURL = api.server.URL
+
+	got, err := google.TranslateBatch([]string{"Hello", "World"}, "DE")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(got, "|") != "Hallo|Welt" {
+		t.Errorf("translations = %v", got)
+	}
+	if api.path != "/language/translate/v2" || api.query != "key=secret" {
+		t.Errorf("request %s?%s", api.path, api.query)
+	}
+	if q := payloadStrings(api.payload, "q"); strings.Join(q, "|") != "Hello|World" || api.payload["target"] != "de" || api.payload["format"] != "html" {
+		t.Errorf("payload = %v", api.payload)
+	}
+
+	if _, err := google.TranslateBatch([]string{"Hello", "World", "Again"}, "DE"); err == nil || !strings.Contains(err.Error(), "数量不匹配") {
+		t.Errorf("mismatched count: err = %v", err)
+	}
+}
+
+func TestDeepLTranslator(t *testing.T) {
+	api := newFakeAPI(t, `{"translations": [{"detected_source_language": "EN", "text": "Hallo"}, {"detected_source_language": "EN", "text": "Welt"}]}`)
+	deepl := NewDeepLTranslator("secret:fx")
+	if deepl.baseURL != "https://api-free.deepl.com" {
+		t.Errorf("free key base URL = %s", deepl.baseURL)
+	}
+	deepl.baseURL = api.server.URL
+
+	got, err := deepl.TranslateBatch([]string{"Hello", "World"}, "DE")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(got, "|") != "Hallo|Welt" {
+		t.Errorf("translations = %v", got)
+	}
+	if api.path != "/v2/translate" || api.header.Get("Authorization") != "DeepL-Auth-Key secret:fx" {
+		t.Errorf("request %s with Authorization %q", api.path, api.header.Get("Authorization"))
+	}
+	if text := payloadStrings(api.payload, "text"); strings.Join(text, "|") != "Hello|World" || api.payload["source_lang"] != "EN" || api.payload["target_lang"] != "DE" {
+		t.Errorf("payload = %v", api.payload)
+	}
+	if ignore := payloadStrings(api.payload, "ignore_tags"); api.payload["tag_handling"] != "xml" || strings.Join(ignore, ",") != "x" {
+		t.Errorf("payload = %v", api.payload)
+	}
+
+	if _, err := deepl.TranslateBatch([]string{"Hello"}, "DE"); err == nil || !strings.Contains(err.Error(), "数量不匹配") {
+		t.Errorf("mismatched count: err = %v", err)
+	}
+}
+
+// 包装为 Chat Completions 响应：模型输出放在第一条 choice 的 message.content 中
+func chatResponse(content string) string {
+	data, _ := json.Marshal(map[string]interface{}{
+		"choices": []interface{}{map[string]interface{}{"message": map[string]string{"role": "assistant", "content": content}}},
+	})
+	return string(data)
+}
+
+// 取出请求中指定角色的消息内容
+func chatMessageContent(payload map[string]interface{}, role string) string {
+	messages, _ := payload["messages"].([]interface{})
+	for _, message := range messages {
+		m, _ := message.(map[string]interface{})
+		if m["role"] == role {
+			content, _ := m["content"].(string)
+			return content
+		}
+	}
+	return ""
+}
+
+func TestOpenAITranslator(t *testing.T) {
+	if err := setProperNouns([]ProperNoun{{Term: "FluxReve"}, {Term: "Nano Banana"}}); err != nil {
+		t.Fatal(err)
+	}
+	defer setProperNouns(nil)
+
+	api := newFakeAPI(t, chatResponse("```json\n"+`{"translations": [{"id": 1, "text": "Speichern"}, {"id": 0, "text": "Willkommen bei FluxReve"}]}`+"\n```"))
+	llm := NewOpenAITranslator("", api.server.URL+"/", "test-model")
+	got, err := llm.TranslateBatchWithContext([]string{"Welcome to FluxReve", "Save"}, [][]string{{"home.title"}, {"actions.save", "dialog.save"}}, "DE")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(got, "|") != "Willkommen bei FluxReve|Speichern" {
+		t.Errorf("translations out of id order: %v", got)
+	}
+	if api.path != "/chat/completions" || api.payload["model"] != "test-model" || api.header.Get("Authorization") != "" {
+		t.Errorf("request %s model %v Authorization %q", api.path, api.payload["model"], api.header.Get("Authorization"))
+	}
+
+	system := chatMessageContent(api.payload, "system")
+	for _, want := range []string{"German", "locale code: de", "FluxReve, Nano Banana"} {
+		if !strings.Contains(system, want) {
+			t.Errorf("system prompt lacks %q:\n%s", want, system)
+		}
+	}
+	var user struct {
+		Items []struct {
+			ID   int      `json:"id"`
+			Keys []string `json:"keys"`
+			Text string   `json:"text"`
+		} `json:"items"`
+	}
+	if err := json.Unmarshal([]byte(chatMessageContent(api.payload, "user")), &user); err != nil {
+		t.Fatal(err)
+	}
+	if len(user.Items) != 2 || user.Items[1].ID != 1 || strings.Join(user.Items[1].Keys, ",") != "actions.save,dialog.save" {
+		t.Errorf("user message items = %+v", user.Items)
+	}
+
+	// 缺少某个编号时整批失败，不用空字符串补齐
+	api = newFakeAPI(t, chatResponse(`{"translations": [{"id": 0, "text": "Hallo"}, {"id": 7, "text": "?"}]}`))
+	llm = NewOpenAITranslator("secret", api.server.URL, "test-model")
+	got, err = llm.TranslateBatch([]string{"Hello", "World"}, "DE")
+	if err == nil || !strings.Contains(err.Error(), "缺少编号 1") || got != nil {
+		t.Errorf("missing id: got %v, err = %v", got, err)
+	}
+	if api.header.Get("Authorization") != "Bearer secret" {
+		t.Errorf("Authorization = %q", api.header.Get("Authorization"))
+	}
+}
+
+func TestOrderedJSONRoundTrip(t *testing.T) {
+	inputs := []string{
+		"{\n  \"zeta\": \"Z & <b>{name}</b>\",\n  \"alpha\": {\n    \"price\": 1.50,\n    \"big\": 12345678901234567890,\n    \"list\": [\n      \"a\",\n      \"\\\"quoted\\\"\",\n      \"日本語 😀\"\n    ],\n    \"empty\": {},\n    \"none\": [],\n    \"flag\": true,\n    \"nothing\": null\n  }\n}\n",
+		"{\n\t\"b\": \"tab\\tindent\",\n\t\"a\": [\n\t\t1,\n\t\t{\n\t\t\t\"y\": \"\\u2028\",\n\t\t\t\"x\": \"\"\n\t\t}\n\t]\n}",
+	}
+	files, _ := filepath.Glob("../messages/en/*.json")
+	for _, file := range files {
+		data, err := ioutil.ReadFile(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		inputs = append(inputs, string(data))
+	}
+
+	for _, input := range inputs {
+		value, err := decodeOrderedJSON([]byte(input))
+		if err != nil {
+			t.Fatalf("decode: %v\n%s", err, input)
+		}
+		output, err := encodeOrderedJSON(value, detectJSONFormat([]byte(input)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(output) != input {
+			t.Errorf("round trip changed file:\n got %q\nwant %q", output, input)
+		}
+	}
+	if len(files) == 0 {
+		t.Log("messages/en not found, only inline samples checked")
+	}
+}
+
+func TestFilterIncremental(t *testing.T) {
+	texts := NewTextCollection()
+	texts.Add("Hello", "greeting")
+	texts.Add("Hello", "nav.hello")
+	texts.Add("Save", "actions.save")
+	texts.Add("Delete", "actions.delete")
+	existing := map[string]string{
+		"greeting":     "Hallo",
+		"actions.save": "",
+		"obsolete":     "Alt",
+	}
+
+	remaining, kept := filterIncremental(texts, existing, &TranslationLock{Hashes: map[string]string{}})
+	if len(kept) != 1 || kept["greeting"] != "Hallo" {
+		t.Errorf("kept = %v", kept)
+	}
+	want := map[string]string{"Hello": "nav.hello", "Save": "actions.save", "Delete": "actions.delete"}
+	if len(remaining.Order) != len(want) {
+		t.Fatalf("remaining = %v", remaining.Order)
+	}
+	for text, path := range want {
+		if paths := remaining.KeyPaths[text]; len(paths) != 1 || paths[0] != path {
+			t.Errorf("remaining[%q] = %v, want [%s]", text, paths, path)
+		}
+	}
+
+	// 锁文件中的原文哈希变化后重新翻译，没有记录的已有译文保留
+	existing["actions.delete"] = "Entfernen"
+	existing["nav.hello"] = "Hallo"
+	lock := &TranslationLock{Hashes: map[string]string{"actions.delete": sourceHash("Remove"), "greeting": sourceHash("Hello")}}
+	remaining, kept = filterIncremental(texts, existing, lock)
+	if len(kept) != 2 || kept["nav.hello"] != "Hallo" || len(remaining.Order) != 2 || remaining.Order[1] != "Delete" {
+		t.Errorf("kept = %v, remaining = %v", kept, remaining.Order)
+	}
+}
+
+func TestStaleDetection(t *testing.T) {
+	if hash := sourceHash("Hello"); len(hash) != 16 || hash != sourceHash("Hello") || hash == sourceHash("Hello!") {
+		t.Errorf("sourceHash(Hello) = %q", hash)
+	}
+
+	lock := &TranslationLock{Hashes: map[string]string{
+		"greeting":     sourceHash("Hello"),
+		"actions.save": sourceHash("Save"),
+	}}
+	if isStale(lock, "greeting", "Hello") {
+		t.Error("unchanged source should not be stale")
+	}
+	if !isStale(lock, "actions.save", "Save changes") {
+		t.Error("changed source should be stale")
+	}
+	if isStale(lock, "manual", "Anything") {
+		t.Error("keys without a recorded hash should not be stale")
+	}
+
+	texts := NewTextCollection()
+	texts.Add("Hello", "greeting")
+	texts.Add("Save changes", "actions.save")
+	remaining, kept := filterIncremental(texts, map[string]string{"greeting": "Hallo", "actions.save": "Speichern"}, lock)
+	if len(kept) != 1 || kept["greeting"] != "Hallo" || len(remaining.Order) != 1 || remaining.Order[0] != "Save changes" {
+		t.Errorf("kept = %v, remaining = %v", kept, remaining.Order)
+	}
+
+	defer func(dir string) { lockRootDir = dir }(lockRootDir)
+	lockRootDir = t.TempDir()
+	if err := saveTranslationLock("messages/de", "home.json", lock); err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := loadTranslationLock("messages/de", "home.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(loaded.Hashes) != 2 || loaded.Hashes["greeting"] != lock.Hashes["greeting"] {
+		t.Errorf("loaded lock = %v", loaded.Hashes)
+	}
+	if empty, err := loadTranslationLock("messages/fr", "home.json"); err != nil || len(empty.Hashes) != 0 {
+		t.Errorf("missing lock = %v, %v", empty, err)
+	}
+}
+
+// 译文为原文加固定前缀的翻译服务，记录调用次数（可被多个 worker 并发调用）
+type prefixTranslator struct {
+	name      string
+	prefix    string
+	languages []string
+
+	mu    sync.Mutex
+	calls int
+}
+
+func (p *prefixTranslator) Name() string                 { return p.name }
+func (p *prefixTranslator) MaxBatchSize() int            { return 100 }
+func (p *prefixTranslator) SupportedLanguages() []string { return p.languages }
+func (p *prefixTranslator) TranslateBatch(texts []string, targetLang string) ([]string, error) {
+	p.mu.Lock()
+	p.calls++
+	p.mu.Unlock()
+	translations := make([]string, len(texts))
+	for i, text := range texts {
+		translations[i] = p.prefix + text
+	}
+	return translations, nil
+}
+
+// 在目录下写入测试文件（文件名 -> 内容）
+func writeTestFiles(t *testing.T, dir string, files map[string]string) {
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestLoadLocaleConfig(t *testing.T) {
+	dir := t.TempDir()
+	writeTestFiles(t, dir, map[string]string{
+		"config/locales.js": "const locales = ['en', 'de',\n  \"zh-CN\"];\nconst defaultLocale = 'en';\n",
+		"i18n/config.ts":    "export const locales = [\"fr\", \"en\", 'ja'] as const;\nexport const defaultLocale: Locale = 'fr';\n",
+	})
+	js, ts, missing := filepath.Join(dir, "config/locales.js"), filepath.Join(dir, "i18n/config.ts"), filepath.Join(dir, "missing.js")
+
+	tests := []struct {
+		paths         []string
+		locales       string
+		defaultLocale string
+	}{
+		{[]string{js, ts}, "en,de,zh-CN", "en"},
+		{[]string{missing, ts}, "fr,en,ja", "fr"},
+		{[]string{"../config/locales.js"}, "en,zh-CN,zh-TW,ja,ko,ar,fr,de,it,es,sv,no,da,fi", "en"},
+		{[]string{"../i18n/config.ts"}, "en,zh-CN,zh-TW,ja,ko,ar,fr,de,it,es,sv,no,da,fi", "en"},
+	}
+	for _, tt := range tests {
+		locales, defaultLocale, err := loadLocaleConfig(tt.paths)
+		if err != nil {
+			t.Errorf("loadLocaleConfig(%v): %v", tt.paths, err)
+			continue
+		}
+		if strings.Join(locales, ",") != tt.locales || defaultLocale != tt.defaultLocale {
+			t.Errorf("loadLocaleConfig(%v) = %v, %q", tt.paths, locales, defaultLocale)
+		}
+		if targets := targetLocales(locales, defaultLocale); len(targets) != len(locales)-1 || strings.Contains(","+strings.Join(targets, ",")+",", ","+defaultLocale+",") {
+			t.Errorf("targetLocales(%v, %q) = %v", locales, defaultLocale, targets)
+		}
+	}
+	if targets := targetLocales([]string{"de", "fr"}, "en"); len(targets) != 2 {
+		t.Errorf("default locale missing from list: targets = %v", targets)
+	}
+	if _, _, err := loadLocaleConfig([]string{missing}); err == nil {
+		t.Error("missing config should fail")
+	}
+}
+
+func TestTranslateAllLocales(t *testing.T) {
+	defer func(cacheDir, lockDir string, limiter *RateLimiter) {
+		cacheRootDir, lockRootDir, rateLimiter = cacheDir, lockDir, limiter
+	}(cacheRootDir, lockRootDir, rateLimiter)
+	messagesDir := t.TempDir()
+	cacheRootDir, lockRootDir = filepath.Join(messagesDir, ".cache"), filepath.Join(messagesDir, ".lock")
+	rateLimiter = NewRateLimiter(0, 1)
+	writeTestFiles(t, messagesDir, map[string]string{
+		"en/home.json":  `{"title": "Hello", "items": ["One", "Two"]}`,
+		"en/about.json": `{"body": "Hello"}`,
+	})
+
+	translator := &prefixTranslator{name: "all-locales-test", prefix: "T: ", languages: []string{"DE", "FR"}}
+	summaries := translateAllLocales(filepath.Join(messagesDir, "en"), []string{"en", "de", "fr", "ja"}, "en", translator)
+	if len(summaries) != 3 {
+		t.Fatalf("summaries = %+v", summaries)
+	}
+	for _, summary := range summaries[:2] {
+		if summary.Err != nil || summary.Files != 2 || summary.Failed != 0 || summary.Requests != 2 || summary.CacheMisses != 3 || summary.CacheHits != 1 {
+			t.Errorf("summary = %+v", summary)
+		}
+	}
+	if summaries[2].Locale != "ja" || summaries[2].Err == nil || summaries[2].Files != 0 {
+		t.Errorf("unsupported locale summary = %+v", summaries[2])
+	}
+	data, err := ioutil.ReadFile(filepath.Join(messagesDir, "fr", "home.json"))
+	if err != nil || !strings.Contains(string(data), `"T: Hello"`) {
+		t.Errorf("fr/home.json = %s, %v", data, err)
+	}
+
+	var out bytes.Buffer
+	printLocaleSummaries(&out, summaries)
+	rows := strings.Split(strings.TrimSpace(out.String()), "\n")
+	if got := strings.Fields(rows[len(rows)-3]); len(got) < 10 || strings.Join(got[:10], " ") != "de DE 2 0 0 0 0 2 1 3" {
+		t.Errorf("de row = %q", rows[len(rows)-3])
+	}
+	if !strings.Contains(rows[len(rows)-1], "ja") || !strings.Contains(rows[len(rows)-1], "❌") {
+		t.Errorf("ja row = %q", rows[len(rows)-1])
+	}
+}
+
+// 假时钟：sleep 只推进时间并记录等待时长
+type fakeClock struct {
+	now    time.Time
+	sleeps []time.Duration
+}
+
+func (c *fakeClock) Now() time.Time { return c.now }
+func (c *fakeClock) Sleep(d time.Duration) {
+	if d > 0 {
+		c.sleeps = append(c.sleeps, d)
+		c.now = c.now.Add(d)
+	}
+}
+
+func TestRateLimiter(t *testing.T) {
+	clock := &fakeClock{now: time.Unix(0, 0)}
+	limiter := NewRateLimiter(10, 3)
+	limiter.now, limiter.sleep, limiter.last = clock.Now, clock.Sleep, clock.now
+
+	// 桶内的 3 个令牌立即可用，之后每个请求等待 1/rate
+	for i := 0; i < 5; i++ {
+		limiter.Wait()
+	}
+	if fmt.Sprint(clock.sleeps) != "[100ms 100ms]" {
+		t.Errorf("sleeps after burst = %v, want [100ms 100ms]", clock.sleeps)
+	}
+
+	// 空闲期间补充的令牌不超过桶容量
+	clock.sleeps = nil
+	clock.now = clock.now.Add(time.Minute)
+	for i := 0; i < 4; i++ {
+		limiter.Wait()
+	}
+	if fmt.Sprint(clock.sleeps) != "[100ms]" {
+		t.Errorf("sleeps after idle = %v, want [100ms]", clock.sleeps)
+	}
+
+	// 部分补充：50ms 只补充半个令牌
+	clock.sleeps = nil
+	clock.now = clock.now.Add(50 * time.Millisecond)
+	limiter.Wait()
+	if fmt.Sprint(clock.sleeps) != "[50ms]" {
+		t.Errorf("sleeps after 50ms = %v, want [50ms]", clock.sleeps)
+	}
+
+	unlimited := NewRateLimiter(0, 1)
+	unlimited.sleep = clock.Sleep
+	clock.sleeps = nil
+	for i := 0; i < 100; i++ {
+		unlimited.Wait()
+	}
+	if len(clock.sleeps) != 0 {
+		t.Errorf("unlimited limiter slept %v", clock.sleeps)
+	}
+}
+
+func TestRunFileJobsConcurrently(t *testing.T) {
+	defer func(cacheDir, lockDir string, limiter *RateLimiter) {
+		cacheRootDir, lockRootDir, rateLimiter = cacheDir, lockDir, limiter
+	}(cacheRootDir, lockRootDir, rateLimiter)
+	messagesDir := t.TempDir()
+	cacheRootDir, lockRootDir = filepath.Join(messagesDir, ".cache"), filepath.Join(messagesDir, ".lock")
+	rateLimiter = NewRateLimiter(0, 1)
+
+	files := map[string]string{}
+	for i := 0; i < 8; i++ {
+		files[fmt.Sprintf("en/ns%d.json", i)] = fmt.Sprintf(`{"shared": "Shared", "own": "Text %d", "list": ["A %d", "B %d"]}`, i, i, i)
+	}
+	writeTestFiles(t, messagesDir, files)
+
+	translator := &prefixTranslator{name: "jobs-test", prefix: "T: ", languages: []string{"DE", "FR"}}
+	jobs := []fileJob{}
+	stats := map[string]*TranslationStats{}
+	for _, lang := range []string{"DE", "FR"} {
+		stats[lang] = &TranslationStats{}
+		localeJobs, err := listFileJobs(filepath.Join(messagesDir, "en"), filepath.Join(messagesDir, strings.ToLower(lang)), lang, stats[lang])
+		if err != nil {
+			t.Fatal(err)
+		}
+		jobs = append(jobs, localeJobs...)
+	}
+	requestsBefore := totalStats.Requests.Load()
+
+	results := runFileJobs(jobs, translator, 4)
+	if len(results) != 16 {
+		t.Fatalf("results = %d", len(results))
+	}
+	for i, result := range results {
+		if result.Err != nil || result.Job.SourceFile != jobs[i].SourceFile || result.Job.Lang != jobs[i].Lang {
+			t.Errorf("result %d = %+v", i, result)
+		}
+	}
+
+	// 每个文件一个批次；命中与未命中之和等于文本总数（共享文本可能被两个 worker 同时翻译）
+	for lang, s := range stats {
+		if s.Requests.Load() != 8 || s.CacheHits.Load()+s.CacheMisses.Load() != 32 || s.CacheMisses.Load() < 25 {
+			t.Errorf("%s stats: requests %d hits %d misses %d", lang, s.Requests.Load(), s.CacheHits.Load(), s.CacheMisses.Load())
+		}
+	}
+	if got := totalStats.Requests.Load() - requestsBefore; got != 16 || translator.calls != 16 {
+		t.Errorf("total requests = %d, translator calls = %d, want 16", got, translator.calls)
+	}
+	for _, lang := range []string{"DE", "FR"} {
+		if translated, ok := getCachedTranslation(cacheKey{Provider: translator.Name(), Lang: lang, Protection: protectionFingerprint, Text: "Shared"}); !ok || translated != "T: Shared" {
+			t.Errorf("cache[%s][Shared] = %q, %v", lang, translated, ok)
+		}
+	}
+	data, err := ioutil.ReadFile(filepath.Join(messagesDir, "fr", "ns3.json"))
+	if err != nil || !strings.Contains(string(data), `"T: Text 3"`) || !strings.Contains(string(data), `"T: B 3"`) {
+		t.Errorf("fr/ns3.json = %s, %v", data, err)
+	}
+}
+
+// 超时的网络错误
+type timeoutError struct{}
+
+func (timeoutError) Error() string   { return "i/o timeout" }
+func (timeoutError) Timeout() bool   { return true }
+func (timeoutError) Temporary() bool { return true }
+
+func TestRetryPolicy(t *testing.T) {
+	retryable := map[error]bool{
+		&APIError{StatusCode: 429}:                         true,
+		&APIError{StatusCode: 503}:                         true,
+		fmt.Errorf("批次失败: %w", &APIError{StatusCode: 502}): true,
+		&APIError{StatusCode: 400}:                         false,
+		&APIError{StatusCode: 401}:                         false,
+		&APIError{StatusCode: 456}:                         false,
+		timeoutError{}:                                     true,
+		fmt.Errorf("网络错误: %w", io.ErrUnexpectedEOF):        true,
+		errors.New("响应解析失败"):                               false,
+	}
+	for err, want := range retryable {
+		if got := isRetryable(err); got != want {
+			t.Errorf("isRetryable(%v) = %v, want %v", err, got, want)
+		}
+	}
+
+	policy := RetryPolicy{MaxAttempts: 5, BaseDelay: time.Second, MaxDelay: 30 * time.Second}
+	bounds := map[int][2]time.Duration{
+		1:  {500 * time.Millisecond, time.Second},
+		3:  {2 * time.Second, 4 * time.Second},
+		10: {15 * time.Second, 30 * time.Second},
+		80: {15 * time.Second, 30 * time.Second},
+	}
+	for attempt, bound := range bounds {
+		for i := 0; i < 50; i++ {
+			if delay := policy.backoff(attempt, errors.New("x")); delay < bound[0] || delay > bound[1] {
+				t.Fatalf("backoff(%d) = %v, want within %v", attempt, delay, bound)
+			}
+		}
+	}
+	if delay := policy.backoff(1, &APIError{StatusCode: 429, RetryAfter: 45 * time.Second}); delay != 45*time.Second {
+		t.Errorf("backoff with Retry-After = %v, want 45s", delay)
+	}
+}
+
+func TestCacheKeyIsolation(t *testing.T) {
+	base := cacheKey{Provider: "Google Cloud Translation", Lang: "DE", Protection: "abcd1234", Text: "Hello"}
+	otherProvider, otherProtection := base, base
+	otherProvider.Provider = "DeepL"
+	otherProtection.Protection = "ffff0000"
+	keys := map[string]bool{base.String(): true, otherProvider.String(): true, otherProtection.String(): true}
+	if len(keys) != 3 || base == otherProvider || base == otherProtection {
+		t.Errorf("cache keys collide: %v", keys)
+	}
+
+	// 专有名词变化后保护指纹随之变化
+	defer setProperNouns(nil)
+	setProperNouns(nil)
+	before := computeProtectionFingerprint()
+	setProperNouns([]ProperNoun{{Term: "FluxReve"}})
+	if computeProtectionFingerprint() == before {
+		t.Error("fingerprint should change with proper nouns")
+	}
+	setProperNouns(nil)
+
+	defer func(limiter *RateLimiter, fingerprint string) {
+		rateLimiter, protectionFingerprint = limiter, fingerprint
+	}(rateLimiter, protectionFingerprint)
+	rateLimiter = NewRateLimiter(0, 1)
+	protectionFingerprint = "test0001"
+
+	first := &prefixTranslator{name: "cache-test-a", prefix: "A: "}
+	second := &prefixTranslator{name: "cache-test-b", prefix: "B: "}
+	texts := []string{"Open the dashboard"}
+	for _, translator := range []*prefixTranslator{first, second, first} {
+		results, err := translateBatch(translator, texts, nil, "DE", map[string]CacheEntry{}, nil, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := translator.prefix + texts[0]; results[texts[0]] != want {
+			t.Errorf("%s: got %q, want %q", translator.name, results[texts[0]], want)
+		}
+	}
+	if first.calls != 1 || second.calls != 1 {
+		t.Errorf("calls = %d/%d, want the second request to each provider served from cache", first.calls, second.calls)
+	}
+
+	protectionFingerprint = "test0002"
+	if _, err := translateBatch(first, texts, nil, "DE", map[string]CacheEntry{}, nil, nil); err != nil {
+		t.Fatal(err)
+	}
+	if first.calls != 2 {
+		t.Error("a new protection fingerprint should miss the cache")
+	}
+}
+
+func TestProtectRestoreRoundTrip(t *testing.T) {
+	texts := []string{
+		"Tom & Jerry's <b>{name}</b> plan",
+		"Learn C# in lesson #1, order 0001",
+		"Use <b>&amp;</b> or '{count}' & \"quotes\"",
+		"Already bracketed ⟦1⟧ text {name}",
+		"a < b > c",
+	}
+	for _, text := range texts {
+		markups := []ProtectionMarkup{htmlMarkup{}, xmlMarkup{}, newSentinelMarkup(text)}
+		for _, markup := range markups {
+			gen := NewPlaceholderGenerator(markup)
+			protected, _ := protectAllContentWithGenerator(text, gen)
+			if strings.Contains(protected, "<b>") {
+				t.Errorf("%T: tag not protected in %q", markup, protected)
+			}
+			restored, missing := restoreProtectedContent(protected, gen)
+			if restored != text || len(missing) > 0 {
+				t.Errorf("%T: restore(%q) = %q (missing %v), want %q", markup, protected, restored, missing, text)
+			}
+		}
+	}
+}
+
+func TestICURoundTrip(t *testing.T) {
+	messages := []string{
+		"{count, plural, =0 {No files} one {# file} other {# files}}",
+		"Use '{'braces'}' for {name}",
+		"It's {count, plural, one {# item} other {'#' # items}}",
+		"{gender, select, male {He} female {She} other {They}} liked it",
+		"{n, selectordinal, one {#st} two {#nd} few {#rd} other {#th}}",
+	}
+	for _, message := range messages {
+		nodes, err := parseICUMessage(message)
+		if err != nil {
+			t.Errorf("parseICUMessage(%q): %v", message, err)
+			continue
+		}
+		if got := formatICUMessage(nodes, false); got != message {
+			t.Errorf("round trip %q = %q", message, got)
+		}
+	}
+
+	nodes, _ := parseICUMessage("Use '{'braces'}' for {name}")
+	if text, ok := nodes[0].(*icuText); !ok || text.Value != "Use {braces} for " {
+		t.Errorf("escaped braces parsed as %#v", nodes[0])
+	}
+	nodes, _ = parseICUMessage(messages[0])
+	if selector, ok := nodes[0].(*icuSelector); !ok || selector.option("=0") == nil {
+		t.Errorf("=0 option missing in %#v", nodes[0])
+	}
+
+	for _, broken := range []string{"{count, plural, one {# file}", "{count, plural, one # file}}"} {
+		if _, err := parseICUMessage(broken); err == nil {
+			t.Errorf("parseICUMessage(%q) should fail", broken)
+		}
+	}
+}
+
+func TestHoistICUSelectors(t *testing.T) {
+	tests := map[string]string{
+		"{gender, select, male {He} female {She} other {They}} liked it": "{gender, select, male {He liked it} female {She liked it} other {They liked it}}",
+		"Hi {name}, {gender, select, male {he} other {they}} won {count, plural, one {# prize} other {# prizes}}!": "{gender, select, male {{count, plural, one {Hi {name}, he won # prize!} other {Hi {name}, he won # prizes!}}} " +
+			"other {{count, plural, one {Hi {name}, they won # prize!} other {Hi {name}, they won # prizes!}}}}",
+		// 嵌套复数中外层的 # 改写为参数，避免被内层复数接管
+		"{a, plural, one {# x {b, plural, one {# y} other {# ys}}} other {# xs}}": "{a, plural, one {{b, plural, one {{a, number} x # y} other {{a, number} x # ys}}} other {# xs}}",
+	}
+	for message, want := range tests {
+		nodes, err := parseICUMessage(message)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := formatICUMessage(hoistICUSelectors(nodes, nil), false); got != want {
+			t.Errorf("hoist %q\n got %q\nwant %q", message, got, want)
+		}
+	}
+}
+
+func TestTranslateMessagesPluralCategories(t *testing.T) {
+	defer func(limiter *RateLimiter) { rateLimiter = limiter }(rateLimiter)
+	rateLimiter = NewRateLimiter(0, 1)
+
+	translator := &prefixTranslator{name: "plural-test"}
+	message := "{count, plural, =0 {No files} one {# file} other {# files}}"
+	tests := map[string][]string{
+		"pl": {"=0", "one", "few", "many", "other"},
+		"ar": {"=0", "zero", "one", "two", "few", "many", "other"},
+		"fr": {"=0", "one", "many", "other"},
+	}
+	for lang, want := range tests {
+		texts := NewTextCollection()
+		texts.Add(message, "files.count")
+		results, err := translateMessages(translator, texts, lang, map[string]CacheEntry{}, nil, map[string]string{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		nodes, err := parseICUMessage(results[message])
+		if err != nil {
+			t.Fatalf("%s: %q: %v", lang, results[message], err)
+		}
+		selector, ok := nodes[0].(*icuSelector)
+		if !ok || len(nodes) != 1 {
+			t.Fatalf("%s: unexpected result %q", lang, results[message])
+		}
+		got := []string{}
+		for _, option := range selector.Options {
+			got = append(got, option.Selector)
+		}
+		if strings.Join(got, " ") != strings.Join(want, " ") {
+			t.Errorf("%s: categories %v, want %v (%q)", lang, got, want, results[message])
+		}
+		if many := selector.option("many"); many != nil && formatICUMessage(many.Message, true) != "# files" {
+			t.Errorf("%s: many = %q", lang, formatICUMessage(many.Message, true))
+		}
+	}
+}
+
+func TestValidateTranslation(t *testing.T) {
+	if err := setProperNouns(benchmarkNouns(0)); err != nil {
+		t.Fatal(err)
+	}
+	defer setProperNouns(nil)
+
+	tests := []struct {
+		source, translated, problem string
+	}{
+		{"Welcome back, {name}!", "Willkommen zurück, {name}!", ""},
+		{"Welcome back, {name}!", "Willkommen zurück!", "缺少 {name}"},
+		{"Save ${amount} today", "Heute sparen", "缺少 ${amount}"},
+		{"{count} of {count}", "{count} von", "{count} 数量不一致 (原文 2, 译文 1)"},
+		{"Hi {name}", "Hallo {nom}", "多出 {nom}"},
+		{"Try FluxReve now", "Jetzt FluxReve testen", ""},
+		{"Try FluxReve now", "지금 FluxReve를 사용해 보세요", ""},
+		{"Try FluxReve now", "Jetzt Flux Reve testen", "缺少 FluxReve"},
+		{"Use Nano Banana Pro", "Nano Banana verwenden", "缺少 Nano Banana Pro"},
+		{"Click <b>here</b>", "Klicken Sie <b>hier</b>", ""},
+		{"Click <b>here</b>", "Klicken Sie hier", "缺少标签"},
+	}
+	for _, tt := range tests {
+		err := validateTranslation(tt.source, tt.translated)
+		switch {
+		case tt.problem == "" && err != nil:
+			t.Errorf("validateTranslation(%q, %q) = %v, want nil", tt.source, tt.translated, err)
+		case tt.problem != "" && (err == nil || !strings.Contains(err.Error(), tt.problem)):
+			t.Errorf("validateTranslation(%q, %q) = %v, want %q", tt.source, tt.translated, err, tt.problem)
+		}
+	}
+}
+
+func TestValidateRichTags(t *testing.T) {
+	tests := []struct {
+		source, translated, problem string
+	}{
+		{"<b>Bold <i>and italic</i></b>", "<b>Fett <i>und kursiv</i></b>", ""},
+		{"Line<br/>break", "Zeilen<br/>umbruch", ""},
+		{"<b><i>x</i></b>", "<b><i>x</b></i>", "<i> 与 </b> 交叉嵌套"},
+		{"<b>x</b>", "<b>x", "<b> 未闭合"},
+		{"<b>x</b>", "x</b>", "</b> 没有对应的开始标签"},
+		{"<b>x</b>", "<strong>x</strong>", "缺少标签 </b>"},
+		{"<link>here</link>", "<link>hier</link> <link>da</link>", "多出标签 </link>"},
+		{"No tags", "Keine Tags", ""},
+	}
+	for _, tt := range tests {
+		err := validateRichTags(tt.source, tt.translated)
+		switch {
+		case tt.problem == "" && err != nil:
+			t.Errorf("validateRichTags(%q, %q) = %v, want nil", tt.source, tt.translated, err)
+		case tt.problem != "" && (err == nil || err.Error() != tt.problem):
+			t.Errorf("validateRichTags(%q, %q) = %v, want %q", tt.source, tt.translated, err, tt.problem)
+		}
+	}
+}
+
+// 替换全局术语表（与 loadGlossaryConfig 一样构建自动机），返回恢复函数
+func setGlossary(terms map[string]map[string]string) func() {
+	saved, savedSources, savedMatcher := glossary, glossarySources, glossaryMatcher
+	sources := []string{}
+	for source := range terms {
+		sources = append(sources, source)
+	}
+	sort.Strings(sources)
+	glossary = GlossaryConfig{Terms: terms}
+	glossarySources = sources
+	glossaryMatcher = newACMatcher(sources)
+	return func() { glossary, glossarySources, glossaryMatcher = saved, savedSources, savedMatcher }
+}
+
+func TestGlossary(t *testing.T) {
+	matches := map[[2]string]bool{
+		{"zh-CN", "ZH"}:      true,
+		{"zh-TW", "ZH"}:      false,
+		{"zh-TW", "ZH-HANT"}: true,
+		{"de", "DE"}:         true,
+		{"pt", "PT-BR"}:      true,
+		{"fr", "DE"}:         false,
+		{"xx", "XX"}:         true,
+	}
+	for pair, want := range matches {
+		if got := glossaryLocaleMatches(pair[0], pair[1]); got != want {
+			t.Errorf("glossaryLocaleMatches(%q, %q) = %v, want %v", pair[0], pair[1], got, want)
+		}
+	}
+
+	defer setGlossary(map[string]map[string]string{
+		"credits":     {"zh-CN": "积分", "de": "Credits"},
+		"credit pack": {"zh-CN": "积分包"},
+		"workspace":   {"zh-CN": "", "de": "arbeitsbereich"},
+	})()
+
+	tests := map[string]string{
+		"ZH": "credit pack=积分包 credits=积分",
+		"DE": "workspace=arbeitsbereich credits=Credits",
+		"FR": "",
+	}
+	for lang, want := range tests {
+		got := []string{}
+		for _, term := range glossaryFor(lang) {
+			got = append(got, term.Source+"="+term.Target)
+		}
+		if strings.Join(got, " ") != want {
+			t.Errorf("glossaryFor(%s) = %v, want %s", lang, got, want)
+		}
+	}
+	if glossarySubstitutions(glossaryFor("FR")) != nil {
+		t.Error("no terms should give nil substitutions")
+	}
+
+	cases := map[[2]string]string{
+		{"Workspace", "arbeitsbereich"}: "Arbeitsbereich",
+		{"workspace", "Arbeitsbereich"}: "Arbeitsbereich",
+		{"workspace", "arbeitsbereich"}: "arbeitsbereich",
+		{"Credits", "积分"}:               "积分",
+	}
+	for pair, want := range cases {
+		if got := matchTermCase(pair[0], pair[1]); got != want {
+			t.Errorf("matchTermCase(%q, %q) = %q, want %q", pair[0], pair[1], got, want)
+		}
+	}
+
+	// 术语按整词、忽略大小写匹配，受保护后翻译服务看不到原词，还原时换成规定译法
+	text := "Workspace credits for your workspace, not workspaces."
+	gen := NewPlaceholderGenerator(htmlMarkup{})
+	gen.Glossary = glossarySubstitutions(glossaryFor("DE"))
+	protected, _ := protectAllContentWithGenerator(text, gen)
+	if strings.Contains(protected, "Workspace") || strings.Contains(protected, "credits") {
+		t.Errorf("terms not protected: %q", protected)
+	}
+	want := "Arbeitsbereich Credits for your arbeitsbereich, not workspaces."
+	if got, _ := restoreProtectedContent(protected, gen); got != want {
+		t.Errorf("restored %q, want %q", got, want)
+	}
+}
+
+func TestGoogleGlossary(t *testing.T) {
+	defer setGlossary(map[string]map[string]string{"credits": {"de": "Credits"}})()
+	glossary.GoogleGlossaries = map[string]string{"de": "projects/p/locations/us-central1/glossaries/terms-de"}
+
+	// 没有 OAuth 访问令牌时不使用 v3 术语表，退回客户端替换
+	google := NewGoogleTranslator("key")
+	if google.PrepareGlossary("DE", glossaryFor("DE")) {
+		t.Error("v3 glossary should not be used without an access token")
+	}
+
+	api := newFakeAPI(t, `{"glossaryTranslations": [{"translatedText": "Credits kaufen"}]}`)
+	google = NewGoogleTranslator("key")
+	google.baseURL = api.server.URL
+	google.accessToken = "token"
+	if !google.PrepareGlossary("DE", glossaryFor("DE")) || google.PrepareGlossary("ZH", glossaryFor("ZH")) {
+		t.Fatal("v3 glossary should be used only for DE")
+	}
+	got, err := google.TranslateBatch([]string{"Buy credits"}, "DE")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 1 || got[0] != "Credits kaufen" {
+		t.Errorf("got %v", got)
+	}
+	if api.path != "/v3/projects/p/locations/us-central1:translateText" || api.query != "" {
+		t.Errorf("request sent to %s?%s", api.path, api.query)
+	}
+	if api.header.Get("Authorization") != "Bearer token" || api.header.Get("x-goog-user-project") != "p" {
+		t.Errorf("auth headers = %q, %q", api.header.Get("Authorization"), api.header.Get("x-goog-user-project"))
+	}
+	config, _ := api.payload["glossaryConfig"].(map[string]interface{})
+	if config["glossary"] != "projects/p/locations/us-central1/glossaries/terms-de" || api.payload["targetLanguageCode"] != "de" {
+		t.Errorf("payload = %v", api.payload)
+	}
+}
+
+func TestProperNounMatching(t *testing.T) {
+	var nouns []ProperNoun
+	if err := json.Unmarshal([]byte(`["Flux", "API", {"term": "nano banana", "match": "case-insensitive"}, {"term": "RTX \\d{4}", "match": "regex"}]`), &nouns); err != nil {
+		t.Fatal(err)
+	}
+	if err := setProperNouns(nouns); err != nil {
+		t.Fatal(err)
+	}
+	defer setProperNouns(nil)
+
+	tests := []struct {
+		text string
+		want []string
+	}{
+		// 整词匹配：Fluxreve、RAPID 中的部分匹配不算
+		{"Flux and Fluxreve", []string{"Flux"}},
+		{"RAPID API access", []string{"API"}},
+		{"flux is lowercase", nil},
+		// 忽略大小写：命中后保留原文的大小写
+		{"Try NANO BANANA or Nano Banana", []string{"NANO BANANA", "Nano Banana"}},
+		// 正则条目不检查词边界
+		{"Runs on RTX 3090 and XRTX 40901", []string{"RTX 3090", "RTX 4090"}},
+	}
+	for _, tt := range tests {
+		g := NewPlaceholderGenerator(newSentinelMarkup(tt.text))
+		_, protected := protectAllContentWithGenerator(tt.text, g)
+		got := []string{}
+		for id := 1; id <= len(protected); id++ {
+			got = append(got, protected[id])
+		}
+		if strings.Join(got, "|") != strings.Join(tt.want, "|") {
+			t.Errorf("%q: protected %q, want %q", tt.text, got, tt.want)
+		}
+	}
+
+	// 无效的正则和匹配方式在加载时报错，错误信息指明出错的条目
+	invalid := map[string]ProperNoun{
+		`RTX (\d`:  {Term: `RTX (\d`, Match: matchRegex},
+		"Seedream": {Term: "Seedream", Match: "fuzzy"},
+	}
+	for term, noun := range invalid {
+		err := setProperNouns([]ProperNoun{noun})
+		if err == nil || !strings.Contains(err.Error(), fmt.Sprintf("%q", term)) {
+			t.Errorf("setProperNouns(%+v) error = %v, want error naming %q", noun, err, term)
+		}
+	}
+}
+
+// 测试文本：典型的营销文案，包含占位符和若干专有名词
+const benchmarkText = "Create stunning 4K UHD images with FluxReve using Flux 2 Pro, Nano Banana Pro and Seedream 4.5. " +
+	"Generate ({credits} credits) per image, upload PNG or WebP files and call the API from GitHub Actions. " +
+	"Welcome back, {name}! Your RTX 4090 renders are ready in {seconds} seconds."
+
+// 生成指定数量的专有名词（前面是真实条目，其余为不会出现在文本中的虚构名称）
+func benchmarkNouns(count int) []ProperNoun {
+	nouns := []ProperNoun{
+		{Term: "Flux 2 Pro", Match: matchCaseInsensitive},
+		{Term: "Nano Banana Pro"}, {Term: "Nano Banana"}, {Term: "Seedream 4.5"}, {Term: "Seedream"},
+		{Term: "FluxReve"}, {Term: "4K UHD"}, {Term: "4K"}, {Term: "API"}, {Term: "GitHub"},
+		{Term: "PNG"}, {Term: "WebP"}, {Term: `RTX \d{4}`, Match: matchRegex},
+	}
+	for i := len(nouns); i < count; i++ {
+		nouns = append(nouns, ProperNoun{Term: fmt.Sprintf("Model %c%c-%d", 'A'+i%26, 'A'+(i/26)%26, i)})
+	}
+	return nouns
+}
+
+// 逐个条目扫描的旧实现，作为基准对照
+func naiveProperNounSpans(text string, nouns []string) []protectedSpan {
+	sorted := append([]string(nil), nouns...)
+	sort.SliceStable(sorted, func(i, j int) bool { return len(sorted[i]) > len(sorted[j]) })
+	spans := []protectedSpan{}
+	for _, noun := range sorted {
+		for offset := 0; ; {
+			index := strings.Index(text[offset:], noun)
+			if index < 0 {
+				break
+			}
+			start := offset + index
+			if !overlapsAny(spans, start, start+len(noun)) {
+				spans = append(spans, protectedSpan{start: start, end: start + len(noun)})
+			}
+			offset = start + len(noun)
+		}
+	}
+	return spans
+}
+
+func TestACMatcherFindsOverlappingTerms(t *testing.T) {
+	m := newACMatcher([]string{"he", "she", "his", "hers"})
+	got := []string{}
+	for _, match := range m.FindAll("ushers") {
+		got = append(got, fmt.Sprintf("%d-%d", match.Start, match.End))
+	}
+	want := "1-4 2-4 2-6"
+	if strings.Join(got, " ") != want {
+		t.Fatalf("FindAll(ushers) = %v, want %s", got, want)
+	}
+}
+
+func TestProperNounMatcher(t *testing.T) {
+	if err := setProperNouns(benchmarkNouns(0)); err != nil {
+		t.Fatal(err)
+	}
+	defer setProperNouns(nil)
+
+	tests := []struct {
+		text string
+		want []string
+	}{
+		{"RAPID API access", []string{"API"}},
+		{"14K and 4K UHD output", []string{"4K UHD"}},
+		{"Use FLUX 2 pro today", []string{"FLUX 2 pro"}},
+		{"Runs on RTX 3090 and RTX 5080", []string{"RTX 3090", "RTX 5080"}},
+		{"Nano Banana Pro vs Nano Banana", []string{"Nano Banana Pro", "Nano Banana"}},
+		{"使用API接口", []string{"API"}},
+		{"Seedreams and fluxreve", nil},
+	}
+	for _, tt := range tests {
+		g := NewPlaceholderGenerator(newSentinelMarkup(tt.text))
+		_, protected := protectAllContentWithGenerator(tt.text, g)
+		got := []string{}
+		for id := 1; id <= len(protected); id++ {
+			got = append(got, protected[id])
+		}
+		if strings.Join(got, "|") != strings.Join(tt.want, "|") {
+			t.Errorf("%q: protected %q, want %q", tt.text, got, tt.want)
+		}
+	}
+}
+
+func BenchmarkProtectAllContent(b *testing.B) {
+	for _, count := range []int{50, 1000, 5000} {
+		b.Run(fmt.Sprintf("nouns=%d", count), func(b *testing.B) {
+			if err := setProperNouns(benchmarkNouns(count)); err != nil {
+				b.Fatal(err)
+			}
+			defer setProperNouns(nil)
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				protectAllContentWithGenerator(benchmarkText, NewPlaceholderGenerator(htmlMarkup{}))
+			}
+		})
+	}
+}
+
+func BenchmarkNaiveProperNounScan(b *testing.B) {
+	for _, count := range []int{50, 1000, 5000} {
+		b.Run(fmt.Sprintf("nouns=%d", count), func(b *testing.B) {
+			terms := []string{}
+			for _, noun := range benchmarkNouns(count) {
+				terms = append(terms, noun.Term)
+			}
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				naiveProperNounSpans(benchmarkText, terms)
+			}
+		})
+	}
+}
+
+func BenchmarkBuildProperNounMatcher(b *testing.B) {
+	nouns := benchmarkNouns(5000)
+	for i := 0; i < b.N; i++ {
+		newProperNounMatcher(nouns)
+	}
+}
+
+func BenchmarkRestoreProtectedContent(b *testing.B) {
+	if err := setProperNouns(benchmarkNouns(50)); err != nil {
+		b.Fatal(err)
+	}
+	defer setProperNouns(nil)
+	g := NewPlaceholderGenerator(newSentinelMarkup(benchmarkText))
+	protected, _ := protectAllContentWithGenerator(benchmarkText, g)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		restoreProtectedContent(protected, g)
+	}
+}
+
+func TestCheckMessages(t *testing.T) {
+	source, _ := decodeOrderedJSON([]byte(`{"title": "Home", "nav": {"a": "A", "b": "B"}, "list": ["x", "y"], "cta": "Go", "blank": ""}`))
+	target, _ := decodeOrderedJSON([]byte(`{"title": " ", "nav": {"a": "A2", "old": "Old"}, "list": "x", "cta": ["Los"], "blank": "", "extra": {"k": "v"}}`))
+	report := &CheckReport{}
+	compareMessages(source, target, "", report)
+
+	if got := strings.Join(report.Missing, ","); got != "nav.b" {
+		t.Errorf("missing = %s", got)
+	}
+	if got := strings.Join(report.Extra, ","); got != "nav.old,extra.k" {
+		t.Errorf("extra = %s", got)
+	}
+	if got := strings.Join(report.Empty, ","); got != "title" {
+		t.Errorf("empty = %s", got)
+	}
+	wantMismatches := []TypeMismatch{{Key: "list", Expected: "array", Actual: "string"}, {Key: "cta", Expected: "string", Actual: "array"}}
+	if fmt.Sprint(report.TypeMismatches) != fmt.Sprint(wantMismatches) {
+		t.Errorf("type mismatches = %v, want %v", report.TypeMismatches, wantMismatches)
+	}
+	if report.issueCount() != 6 {
+		t.Errorf("issueCount = %d, want 6", report.issueCount())
+	}
+
+	// 子命令：全部通过时退出码为 0，有问题时为 1
+	messagesDir := t.TempDir()
+	files := map[string]string{
+		"en/home.json": `{"title": "Home", "items": ["a"]}`,
+		"de/home.json": `{"title": "Startseite", "items": ["a"]}`,
+		"fr/home.json": `{"title": "Accueil"}`,
+	}
+	writeTestFiles(t, messagesDir, files)
+	output := filepath.Join(messagesDir, "report.json")
+	args := []string{"-source", filepath.Join(messagesDir, "en"), "-format", "json", "-output", output}
+	if code := runCheckCommand(append(args, "-locales", "de")); code != 0 {
+		t.Errorf("check de exit code = %d, want 0", code)
+	}
+	if code := runCheckCommand(append(args, "-locales", "de,fr")); code != 1 {
+		t.Errorf("check de,fr exit code = %d, want 1", code)
+	}
+	data, err := ioutil.ReadFile(output)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(data), `"items.0"`) {
+		t.Errorf("json report does not list the missing key:\n%s", data)
+	}
+}
+
+func TestMergeLocaleMessages(t *testing.T) {
+	messagesDir := t.TempDir()
+	writeTestFiles(t, messagesDir, map[string]string{
+		"de/home.json":   `{"zeta": "Z", "alpha": "A"}`,
+		"de/common.json": `{"ok": "OK"}`,
+		"de/notes.txt":   "ignored",
+	})
+	count, err := mergeLocaleMessages(messagesDir, "de")
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, _ := ioutil.ReadFile(filepath.Join(messagesDir, "de.json"))
+	want := `{"common":{"ok":"OK"},"home":{"zeta":"Z","alpha":"A"}}`
+	if got := strings.Join(strings.Fields(string(data)), ""); count != 2 || got != want {
+		t.Errorf("merged %d namespaces: %s, want %s", count, got, want)
+	}
+
+	failures := map[string]map[string]string{
+		"命名空间冲突":  {"fr/common.json": `{}`, "fr/Common.json": `{}`},
+		"解析":      {"fr/home.json": `{"title": }`},
+		"顶层必须是对象": {"fr/list.json": `["a"]`},
+	}
+	for problem, files := range failures {
+		dir := t.TempDir()
+		writeTestFiles(t, dir, files)
+		if _, err := mergeLocaleMessages(dir, "fr"); err == nil || !strings.Contains(err.Error(), problem) {
+			t.Errorf("%v: err = %v, want %q", files, err, problem)
+		}
+		if _, err := os.Stat(filepath.Join(dir, "fr.json")); !os.IsNotExist(err) {
+			t.Errorf("%v: bundle should not be written on failure", files)
+		}
+	}
+}
+
+func TestDetectUntranslated(t *testing.T) {
+	if err := setProperNouns(benchmarkNouns(0)); err != nil {
+		t.Fatal(err)
+	}
+	defer setProperNouns(nil)
+
+	tests := []struct {
+		source, translated, lang string
+		flagged                  bool
+	}{
+		{"Generate stunning images in seconds", "Generate stunning images in seconds", "DE", true},
+		{"Your credits will be refunded automatically if generation fails", "Your credits will be refunded automatically if generation fails.", "FR", true},
+		{"Your credits will be refunded automatically", "Ihre Credits werden automatisch erstattet, wenn die Generierung fehlschlägt", "DE", false},
+		{"Ultra HD", "Ultra HD", "DE", false},
+		{"Upload PNG or WebP", "Upload PNG or WebP", "IT", false},
+		{"Upload your PNG or WebP files", "Upload your PNG or WebP files", "IT", true},
+		{"Loading...", "Loading...", "JA", true},
+		{"Try Flux 2 Pro", "Flux 2 Pro 무료 체험", "KO", false},
+		{"50K+", "50K+", "ZH", false},
+		{"Create image", "创建图像", "zh-TW", true},
+		{"Create image", "創建圖像", "ZH-HANT", false},
+		{"Create image", "創建圖像", "ZH", true},
+	}
+	for _, tt := range tests {
+		reason := detectUntranslated(tt.source, tt.translated, tt.lang)
+		if (reason != "") != tt.flagged {
+			t.Errorf("%s %q: reason %q, want flagged=%v", tt.lang, tt.translated, reason, tt.flagged)
+		}
+	}
+}
+
+func TestLengthRules(t *testing.T) {
+	lengthRules = []LengthRule{
+		{Key: "pricing:billing.discount", Max: 10, Unit: lengthUnitWidth},
+		{Key: "**.title", Max: 20, Unit: lengthUnitChars},
+	}
+	defer func() { lengthRules = nil }()
+
+	tests := []struct {
+		namespace, path string
+		want            string
+	}{
+		{"pricing", "billing.discount", "pricing:billing.discount"},
+		{"home", "billing.discount", ""},
+		{"home", "seo.title", "**.title"},
+		{"home", "title", "**.title"},
+		{"home", "seo.titles", ""},
+	}
+	for _, tt := range tests {
+		got := ""
+		if rule := lengthRuleFor(tt.namespace, tt.path); rule != nil {
+			got = rule.Key
+		}
+		if got != tt.want {
+			t.Errorf("lengthRuleFor(%s, %s) = %q, want %q", tt.namespace, tt.path, got, tt.want)
+		}
+	}
+
+	for text, want := range map[string]int{"Save 20%": 8, "节省 20%": 8, "20%割引": 7, "<b>할인</b>": 4} {
+		if got := (LengthRule{Unit: lengthUnitWidth}).measure(text); got != want {
+			t.Errorf("measure(%q) = %d, want %d", text, got, want)
+		}
+	}
+}
+
+func TestResolveLocale(t *testing.T) {
+	tests := map[string]string{
+		"zh-CN":      "ZH",
+		"zh-TW":      "ZH-HANT",
+		"zh_Hant_HK": "ZH-HANT",
+		"zh-Hant-MO": "ZH-HANT",
+		"pt":         "PT-BR",
+		"pt-PT":      "PT-PT",
+		"no":         "NO",
+		"nb":         "NO",
+		"de-AT":      "DE",
+		"DE":         "DE",
+	}
+	for tag, want := range tests {
+		info, err := resolveLocale(tag)
+		if err != nil || info.Code != want {
+			t.Errorf("resolveLocale(%q) = %v, %v; want %s", tag, info, err, want)
+		}
+	}
+	for _, tag := range []string{"xx", "klingon", ""} {
+		if _, err := resolveLocale(tag); err == nil {
+			t.Errorf("resolveLocale(%q) should fail", tag)
+		}
+	}
+	if info, _ := resolveLocale("zh-TW"); info.Google != "zh-TW" || info.DeepL != "ZH-HANT" {
+		t.Errorf("zh-TW maps to %s / %s", info.Google, info.DeepL)
+	}
+}
+
+func TestChineseConverter(t *testing.T) {
+	dictionaries := [][]string{
+		{"复制\t複製\n# 注释\n", "复\t複 復\n制\t制\n设\t設\n置\t置\n后\t後\n着\t着\n"},
+		{"設置\t設定\n"},
+		{"着\t著\n"},
+	}
+	converter := &ChineseConverter{}
+	for _, files := range dictionaries {
+		stage := &conversionStage{entries: make(map[string]string)}
+		for _, data := range files {
+			if err := stage.load([]byte(data)); err != nil {
+				t.Fatal(err)
+			}
+		}
+		converter.stages = append(converter.stages, stage)
+	}
+
+	tests := map[string]string{
+		"复制设置":          "複製設定",
+		"重复着":           "重複著",
+		"然后 ⟦1⟧ 复制 {n}": "然後 ⟦1⟧ 複製 {n}",
+		"API":           "API",
+	}
+	for text, want := range tests {
+		if got := converter.Convert(text); got != want {
+			t.Errorf("Convert(%q) = %q, want %q", text, got, want)
+		}
+	}
+	if err := (&conversionStage{entries: make(map[string]string)}).load([]byte("缺少制表符\n")); err == nil {
+		t.Error("malformed line should fail")
+	}
+}
+
+func TestPseudoLocalize(t *testing.T) {
+	if err := setProperNouns(benchmarkNouns(0)); err != nil {
+		t.Fatal(err)
+	}
+	defer setProperNouns(nil)
+
+	tests := []struct {
+		text string
+		opts PseudoOptions
+		want string
+	}{
+		{"Save {amount} now", PseudoOptions{Brackets: true}, "[Šáṽé {amount} ñöŵ]"},
+		{"Try FluxReve", PseudoOptions{}, "Ţŕý FluxReve"},
+		{"<b>Hi</b> there", PseudoOptions{Expansion: 40}, "<b>Ĥî</b> ţĥéŕé ~~~"},
+		{"{count, plural, one {# image} other {# images}}", PseudoOptions{}, "{count, plural, one {# îṁáĝé} other {# îṁáĝéš}}"},
+		{"Hi {name}", PseudoOptions{RTL: true, Brackets: true}, "[\u202eHi \u202c{name}]"},
+	}
+	for _, tt := range tests {
+		if got := pseudoLocalize(tt.text, tt.opts); got != tt.want {
+			t.Errorf("pseudoLocalize(%q) = %q, want %q", tt.text, got, tt.want)
+		}
+	}
+}
+
+func TestXLIFFRoundTrip(t *testing.T) {
+	if err := setProperNouns(benchmarkNouns(0)); err != nil {
+		t.Fatal(err)
+	}
+	defer setProperNouns(nil)
+
+	entries := []ExportEntry{
+		{File: "pricing.json", Key: "billing.save", Source: "Save ${amount} with {plan} & FluxReve", Target: "Sparen Sie ${amount} mit {plan} & FluxReve"},
+		{File: "pricing.json", Key: "tiers.0", Source: "<b>{n}</b> of {n}", Target: "<b>{n}</b> von {n}", Stale: true},
+		{File: "home.json", Key: "title", Source: "Welcome"},
+	}
+	trgLang, items, skipped, err := parseXLIFF(buildXLIFF("en", "de", entries))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if trgLang != "de" || skipped != 2 || len(items) != 1 {
+		t.Fatalf("trgLang=%q skipped=%d items=%v", trgLang, skipped, items)
+	}
+	want := ImportedTranslation{File: "pricing.json", Key: "billing.save", Source: entries[0].Source, Target: entries[0].Target}
+	if items[0] != want {
+		t.Errorf("parsed %+v, want %+v", items[0], want)
+	}
+
+	// CAT 工具可能把成对的标签改写为 <pc>
+	doc := `<xliff version="2.0" trgLang="ja"><file original="a.json"><unit name="k">` +
+		`<originalData><data id="d1">&lt;b&gt;</data><data id="d2">&lt;/b&gt;</data></originalData>` +
+		`<segment state="final"><source><pc id="1" dataRefStart="d1" dataRefEnd="d2">Hi</pc></source>` +
+		`<target><pc id="1" dataRefStart="d1" dataRefEnd="d2">やあ</pc></target></segment></unit></file></xliff>`
+	if _, items, _, err := parseXLIFF([]byte(doc)); err != nil || len(items) != 1 || items[0].Target != "<b>やあ</b>" {
+		t.Errorf("pc parse = %v, %v", items, err)
+	}
+
+	// <file> 按出现顺序编号，与单元序号无关
+	ids := regexp.MustCompile(`<file id="([^"]+)"`).FindAllStringSubmatch(string(buildXLIFF("en", "de", entries)), -1)
+	if len(ids) != 2 || ids[0][1] != "f1" || ids[1][1] != "f2" {
+		t.Errorf("file ids = %v", ids)
+	}
+}
+
+func TestXLIFFICUMessages(t *testing.T) {
+	entries := []ExportEntry{
+		{File: "gallery.json", Key: "count", Source: "{count, plural, =0 {No images yet} one {# image by {author}} other {# images by {author}}}",
+			Target: "{count, plural, =0 {Noch keine Bilder} one {# Bild von {author}} other {# Bilder von {author}}}"},
+		{File: "gallery.json", Key: "role", Source: "{role, select, admin {You can <b>edit</b> it} other {Read-only, don't edit}}",
+			Target: "{role, select, admin {Sie können es <b>bearbeiten</b>} other {Nur lesen}}"},
+	}
+	doc := string(buildXLIFF("en", "de", entries))
+
+	// 选项中的文字可以翻译，只有选择器语法、# 和参数是 <ph>
+	for _, text := range []string{">No images yet<", "> image by <", "> images by <", ">You can <", ">edit<", "Read-only, don't edit", "> Bilder von <"} {
+		if !strings.Contains(doc, text) {
+			t.Errorf("XLIFF does not expose %q as translatable text:\n%s", text, doc)
+		}
+	}
+	for _, data := range []string{"{count, plural, =0 {", "} one {", "} other {", "}}", "#", "{author}", "{role, select, admin {"} {
+		if !strings.Contains(doc, `>`+xmlEscape(data)+`</data>`) {
+			t.Errorf("missing originalData %q:\n%s", data, doc)
+		}
+	}
+
+	// 导入时按顺序拼接，还原为完整的 ICU 消息
+	_, items, skipped, err := parseXLIFF([]byte(doc))
+	if err != nil || skipped != 0 || len(items) != 2 {
+		t.Fatalf("items=%v skipped=%d err=%v", items, skipped, err)
+	}
+	for i, item := range items {
+		if item.Source != entries[i].Source || item.Target != entries[i].Target {
+			t.Errorf("parsed %+v, want %+v", item, entries[i])
+		}
+	}
+
+	// 括号被破坏的译文不能导入
+	if err := validateICUSyntax(entries[0].Source, "{count, plural, one {# Bild} other {# Bilder}"); err == nil {
+		t.Error("broken ICU translation should be rejected")
+	}
+	if err := validateICUSyntax("Hello {name}", "Hallo {name"); err != nil {
+		t.Errorf("plain messages are not checked: %v", err)
+	}
+}
+
+func TestMergeImported(t *testing.T) {
+	source, _ := decodeOrderedJSON([]byte(`{"a": "A", "b": {"x": "X", "y": "Y"}, "c": ["1", "2"], "d": "D"}`))
+	target, _ := decodeOrderedJSON([]byte(`{"a": "a", "b": {"y": "y"}, "c": ["one"], "extra": "keep"}`))
+	merged := mergeImported(source, target, "", map[string]string{"b.x": "x", "c.1": "two", "d": "d"})
+	output, err := encodeOrderedJSON(merged, JSONFormat{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `{"a":"a","b":{"x":"x","y":"y"},"c":["one","two"],"d":"d","extra":"keep"}`
+	if got := strings.Join(strings.Fields(string(output)), ""); got != want {
+		t.Errorf("merged = %s, want %s", got, want)
+	}
+
+	// 类型与源文件不一致、且没有导入内容的键保持原值
+	source, _ = decodeOrderedJSON([]byte(`{"a": {"x": "X"}, "b": ["1"], "c": "C"}`))
+	target, _ = decodeOrderedJSON([]byte(`{"a": "oops", "b": "oops2", "c": "c"}`))
+	output, err = encodeOrderedJSON(mergeImported(source, target, "", map[string]string{"c": "cc"}), JSONFormat{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want = `{"a":"oops","b":"oops2","c":"cc"}`
+	if got := strings.Join(strings.Fields(string(output)), ""); got != want {
+		t.Errorf("merged = %s, want %s", got, want)
+	}
+}
+
+func TestPORoundTrip(t *testing.T) {
+	entries := []ExportEntry{
+		{File: "pricing.json", Key: "faq.0.answer", Source: "Line one\nSay \"hi\" to {name}\n", Target: "Zeile eins\nSag \"hallo\" zu {name}\n"},
+		{File: "pricing.json", Key: "billing.save", Source: "Save ${amount}", Target: "Spare ${amount}", Stale: true},
+		{File: "home.json", Key: "title", Source: "Welcome"},
+	}
+	trgLang, items, skipped, err := parsePO(buildPO("en", "de", entries))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if trgLang != "de" || skipped != 2 || len(items) != 1 {
+		t.Fatalf("trgLang=%q skipped=%d items=%v", trgLang, skipped, items)
+	}
+	want := ImportedTranslation{File: "pricing.json", Key: "faq.0.answer", Source: entries[0].Source, Target: entries[0].Target}
+	if items[0] != want {
+		t.Errorf("parsed %+v, want %+v", items[0], want)
+	}
+
+	if _, _, _, err := parsePO([]byte("msgid \"Hello\"\nmsgstr \"Hallo\"\n")); err == nil {
+		t.Error("entry without msgctxt should fail")
+	}
+}